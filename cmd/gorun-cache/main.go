@@ -0,0 +1,28 @@
+// Command gorun-cache is a reference remote cache server for gorund, see
+// GORUN_REMOTE_CACHE
+package main
+
+import (
+	"flag"
+	"net/http"
+	"os"
+
+	log "github.com/lukemassa/clilog"
+	"github.com/lukemassa/gorun/internal/remote"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:8390", "address to listen on")
+	dir := flag.String("dir", "gorun-cache", "directory to store binaries in")
+	flag.Parse()
+
+	err := os.MkdirAll(*dir, 0o755)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("Serving %s at http://%s", *dir, *addr)
+	err = http.ListenAndServe(*addr, remote.Handler(*dir))
+	if err != nil {
+		log.Fatal(err)
+	}
+}
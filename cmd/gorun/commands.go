@@ -0,0 +1,409 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/lukemassa/clilog"
+	"github.com/lukemassa/gorun/internal/build"
+	"github.com/lukemassa/gorun/internal/client"
+	"github.com/lukemassa/gorun/internal/server"
+)
+
+// command is a gorun subcommand
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(c *client.Client, args []string)
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"run", "[flags] [--] package [arguments...]", "Build the package if needed, and run it with the arguments.", runCommand},
+		{"rebuild", "[flags] package", "Build the package again, even if it is up to date.", rebuildCommand},
+		{"evict", "[flags] package", "Remove the package's executable from the cache.", evictCommand},
+		{"which", "[flags] package", "Build the package if needed, and print the path of its executable instead of running it.", whichCommand},
+		{"list", "", "List the executables in the cache.", listCommand},
+		{"status", "", "Report whether gorund is running.", statusCommand},
+		{"clean", "", "Remove every executable from the cache.", cleanCommand},
+		{"history", "[flags]", "List the latest runs, the latest first.", historyCommand},
+		{"help", "", "Show this help.", func(*client.Client, []string) { usage() }},
+	}
+}
+
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+func usage() {
+	w := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Usage: gorun <command> [arguments]\n")
+	fmt.Fprintf(w, "       gorun [flags] package [--] [arguments...], short for gorun run\n\n")
+	fmt.Fprintf(w, "Commands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s\t%s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(w, "\nRun gorun <command> -h for the flags of each command.\n")
+	w.Flush()
+}
+
+// checkDaemon exits with a friendly message if err means gorund is not running,
+// for commands that have no reason to start it
+func checkDaemon(err error) {
+	if daemonDown(err) {
+		log.Fatal("gorund is not running")
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// daemonDown reports whether err means there is no gorund to talk to
+func daemonDown(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ENOENT)
+}
+
+func runCommand(c *client.Client, args []string) {
+	cmd, _ := findCommand("run")
+	fs := newFlagSet(cmd)
+	var request server.ExecutableRequest
+	var opts options
+	finishContext := contextFlags(fs, &request)
+	finishBuild := buildFlags(fs, &request, &opts)
+	fs.BoolVar(&opts.supervise, "supervise", boolFromEnv("GORUN_SUPERVISE", false), "run the executable as a child, forwarding signals to it, and report how the run went to gorund (default from GORUN_SUPERVISE)")
+	mainPackage, mainArgs := parsePackage(fs, args)
+	finishContext()
+	finishBuild()
+	request.MainPackage = mainPackage
+
+	response := getExecutable(c, request, opts)
+	executable := response.Executable
+	if response.Stale {
+		log.Warnf("Running a stale build of %s, a rebuild is under way", mainPackage)
+	}
+	execArgs := []string{executable}
+	execArgs = append(execArgs, mainArgs...)
+
+	log.Debugf("Compiled context for %q to %q, passing additional args %v", mainPackage, executable, mainArgs)
+
+	report := server.RunReport{
+		MainPackage: cmp.Or(response.MainPackage, mainPackage),
+		Directory:   cmp.Or(response.Directory, os.Getenv("PWD")),
+		Flags:       request.Flags,
+		Args:        mainArgs,
+		Executable:  executable,
+	}
+	if opts.supervise {
+		supervise(c, report, request.Env)
+		// Unreachable
+	}
+	// Only the start of the run can be recorded, since gorun is about to go
+	report.StartedAt = time.Now()
+	err := c.RecordRun(report)
+	if err != nil {
+		log.Debugf("Failed to report the run to gorund: %v", err)
+	}
+	err = syscall.Exec(executable, execArgs, request.Env)
+	if err != nil {
+		log.Fatalf("exec failed: %v", err)
+	}
+	// Unreachable
+}
+
+// contextRequest parses the flags and package of commands that only need the
+// build context
+func contextRequest(name string, args []string) server.ExecutableRequest {
+	cmd, _ := findCommand(name)
+	fs := newFlagSet(cmd)
+	var request server.ExecutableRequest
+	finish := contextFlags(fs, &request)
+	mainPackage, rest := parsePackage(fs, args)
+	if len(rest) > 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	finish()
+	request.MainPackage = mainPackage
+	return request
+}
+
+func rebuildCommand(c *client.Client, args []string) {
+	request := contextRequest("rebuild", args)
+	checkDaemon(c.RebuildCommand(request))
+}
+
+func evictCommand(c *client.Client, args []string) {
+	request := contextRequest("evict", args)
+	evicted, err := c.EvictCommand(request)
+	checkDaemon(err)
+	if !evicted {
+		log.Warnf("%s was not in the cache", request.MainPackage)
+	}
+}
+
+func whichCommand(c *client.Client, args []string) {
+	cmd, _ := findCommand("which")
+	fs := newFlagSet(cmd)
+	asJSON := fs.Bool("json", false, "print the path along with what it was built from, as JSON")
+	var request server.ExecutableRequest
+	var opts options
+	finishContext := contextFlags(fs, &request)
+	finishBuild := buildFlags(fs, &request, &opts)
+	mainPackage, rest := parsePackage(fs, args)
+	if len(rest) > 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	finishContext()
+	finishBuild()
+	request.MainPackage = mainPackage
+
+	response := getExecutable(c, request, opts)
+	if response.Stale {
+		log.Warnf("%s is a stale build, a rebuild is under way", response.Executable)
+	}
+	if !*asJSON {
+		fmt.Println(response.Executable)
+		return
+	}
+	result := whichResult{
+		Executable:  response.Executable,
+		MainPackage: request.MainPackage,
+		Directory:   os.Getenv("PWD"),
+		Flags:       request.Flags,
+		Toolchain:   request.Toolchain,
+		GoVersion:   response.GoVersion,
+		BuiltAt:     response.BuiltAt,
+		Stale:       response.Stale,
+		Fallback:    response.Fallback != "",
+	}
+	if info, err := os.Stat(response.Executable); err == nil {
+		result.Size = info.Size()
+	}
+	printJSON(result)
+}
+
+// whichResult is what gorun which -json prints
+type whichResult struct {
+	Executable  string
+	MainPackage string
+	Directory   string
+	Flags       build.Flags
+	Toolchain   string `json:",omitempty"`
+	GoVersion   string `json:",omitempty"`
+	Size        int64
+	BuiltAt     time.Time `json:",omitzero"`
+	// Stale is true if Executable is a previous build, see -stale
+	Stale bool
+	// Fallback is true if Executable is the last good build, see -fallback
+	Fallback bool
+}
+
+func listCommand(c *client.Client, args []string) {
+	cmd, _ := findCommand("list")
+	fs := newFlagSet(cmd)
+	asJSON := fs.Bool("json", false, "print the commands as JSON")
+	parseNoArgs(fs, args)
+	commands, err := c.ListCommands()
+	checkDaemon(err)
+
+	if *asJSON {
+		printJSON(commands)
+		return
+	}
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PACKAGE\tDIRECTORY\tFLAGS\tSIZE\tBUILT\tTOOK\tHITS\tLAST USED\tSTATUS\tEXECUTABLE")
+	for _, command := range commands {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+			command.MainPackage,
+			command.Directory,
+			strings.Join(command.Flags.Args(), " "),
+			formatBytes(command.Size),
+			formatAgo(now, command.BuiltAt),
+			formatDuration(command.BuildDuration),
+			command.Hits,
+			formatAgo(now, command.LastUsed),
+			commandStatus(command),
+			command.Executable,
+		)
+	}
+	w.Flush()
+}
+
+// commandStatus sums up the state of a cached command in a word
+func commandStatus(command server.CommandInfo) string {
+	switch {
+	case command.Building:
+		return "building"
+	case command.Failed:
+		return "failed"
+	case command.Stale:
+		return "stale"
+	}
+	return "fresh"
+}
+
+func printJSON(v any) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(v)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// formatAgo renders how long before now t was, like 5m ago
+func formatAgo(now, t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	d := now.Sub(t).Round(time.Second)
+	if d < time.Second {
+		return "just now"
+	}
+	return formatDuration(d) + " ago"
+}
+
+// formatDuration renders d to a precision that suits its size
+func formatDuration(d time.Duration) string {
+	switch {
+	case d <= 0:
+		return "-"
+	case d < time.Second:
+		return d.Round(time.Millisecond).String()
+	case d < time.Minute:
+		return d.Round(100 * time.Millisecond).String()
+	case d < 24*time.Hour:
+		return d.Round(time.Second).String()
+	}
+	return fmt.Sprintf("%dd", int(d/(24*time.Hour)))
+}
+
+func statusCommand(c *client.Client, args []string) {
+	cmd, _ := findCommand("status")
+	parseNoArgs(newFlagSet(cmd), args)
+	status, err := c.Status()
+	checkDaemon(err)
+	fmt.Printf("gorund is running as pid %d, in %s\n", status.Pid, status.WorkingDir)
+	fmt.Printf("Up for %s\n", time.Since(status.StartedAt).Round(time.Second))
+	fmt.Printf("%d cached commands, %d building\n", status.Commands, status.Building)
+}
+
+func cleanCommand(c *client.Client, args []string) {
+	cmd, _ := findCommand("clean")
+	parseNoArgs(newFlagSet(cmd), args)
+	result, err := c.Clean()
+	checkDaemon(err)
+	fmt.Printf("Removed %d executables, freeing %s\n", result.Removed, formatBytes(result.Freed))
+}
+
+func historyCommand(c *client.Client, args []string) {
+	cmd, _ := findCommand("history")
+	fs := newFlagSet(cmd)
+	var filter server.RunFilter
+	var since string
+	fs.StringVar(&filter.MainPackage, "package", "", "only runs of packages containing this")
+	fs.StringVar(&filter.Directory, "dir", "", "only runs in this directory or below it")
+	fs.StringVar(&since, "since", "", "only runs since this long ago, like 24h, or this date, like 2006-01-02")
+	fs.StringVar(&filter.Status, "status", "", "only runs that exited this way, ok or failed, which needs -supervise")
+	fs.IntVar(&filter.Limit, "n", 20, "the most runs to show, or 0 for all of them")
+	asJSON := fs.Bool("json", false, "print the runs as JSON")
+	parseNoArgs(fs, args)
+
+	if filter.Directory != "" {
+		dir, err := filepath.Abs(filter.Directory)
+		if err != nil {
+			log.Fatalf("Invalid -dir: %v", err)
+		}
+		filter.Directory = dir
+	}
+	if since != "" {
+		filter.Since = parseSince(since)
+	}
+	runs, err := c.ListRuns(filter)
+	checkDaemon(err)
+
+	if *asJSON {
+		printJSON(runs)
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "STARTED\tPACKAGE\tARGS\tDIRECTORY\tBUILD\tTOOK\tEXIT\tPEAK RSS")
+	for _, run := range runs {
+		took, exit, rss := "-", "-", "-"
+		if run.Supervised {
+			took = formatDuration(run.Duration)
+			exit = strconv.Itoa(run.ExitCode)
+			if run.Signal != "" {
+				exit += " (" + run.Signal + ")"
+			}
+			rss = formatBytes(run.PeakRSS)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			run.StartedAt.Local().Format(time.DateTime),
+			run.MainPackage,
+			strings.Join(run.Args, " "),
+			run.Directory,
+			shortBuildID(run.BuildID),
+			took,
+			exit,
+			rss,
+		)
+	}
+	w.Flush()
+}
+
+// parseSince parses -since, either a duration before now or a date
+func parseSince(since string) time.Time {
+	if d, err := time.ParseDuration(since); err == nil {
+		return time.Now().Add(-d)
+	}
+	for _, layout := range []string{time.RFC3339, time.DateTime, time.DateOnly} {
+		if t, err := time.ParseInLocation(layout, since, time.Local); err == nil {
+			return t
+		}
+	}
+	log.Fatalf("Invalid -since %q, expected a duration like 24h or a date like 2006-01-02", since)
+	return time.Time{}
+}
+
+// shortBuildID abbreviates a build ID the way git abbreviates commits
+func shortBuildID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// formatBytes renders a size in the binary units GORUN_CACHE_MAX_BYTES takes,
+// like 12.3 MiB
+func formatBytes(n int64) string {
+	const unit = 1 << 10
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/lukemassa/clilog"
+	"github.com/lukemassa/gorun/internal/build"
+)
+
+// Exit code when the package failed to compile, as opposed to gorun itself
+// failing, which exits 1, or being run wrongly, which exits 2
+const exitCompileError = 3
+
+// fatal reports err and exits, rendering compile errors the way go build does
+func fatal(err error) {
+	if !printCompileError(err) {
+		log.Fatal(err)
+	}
+	os.Exit(exitCompileError)
+}
+
+// printCompileError renders err the way go build does, if it is a compile
+// error, returning whether it was
+func printCompileError(err error) bool {
+	var compileErr *build.CompileError
+	if !errors.As(err, &compileErr) {
+		return false
+	}
+	if len(compileErr.Diagnostics) == 0 {
+		fmt.Fprint(os.Stderr, compileErr.Output)
+		if !strings.HasSuffix(compileErr.Output, "\n") {
+			fmt.Fprintln(os.Stderr)
+		}
+	} else {
+		cwd, _ := os.Getwd()
+		renderDiagnostics(os.Stderr, compileErr.Diagnostics, cwd)
+	}
+	return true
+}
+
+func renderDiagnostics(w io.Writer, diagnostics []build.Diagnostic, cwd string) {
+	var pkg string
+	for _, d := range diagnostics {
+		if d.Package != pkg {
+			pkg = d.Package
+			fmt.Fprintf(w, "# %s\n", pkg)
+		}
+		if d.File == "" {
+			fmt.Fprintln(w, d.Message)
+			continue
+		}
+		location := relativePath(d.File, cwd)
+		if d.Line > 0 {
+			location = fmt.Sprintf("%s:%d", location, d.Line)
+		}
+		if d.Column > 0 {
+			location = fmt.Sprintf("%s:%d", location, d.Column)
+		}
+		fmt.Fprintf(w, "%s: %s\n", location, d.Message)
+	}
+}
+
+// relativePath shortens path relative to cwd, the way the go command does
+func relativePath(path, cwd string) string {
+	if cwd == "" {
+		return path
+	}
+	rel, err := filepath.Rel(cwd, path)
+	if err != nil || len(rel) >= len(path) {
+		return path
+	}
+	if !filepath.IsLocal(rel) {
+		return rel
+	}
+	return "." + string(filepath.Separator) + rel
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	log "github.com/lukemassa/clilog"
+	"github.com/lukemassa/gorun/internal/config"
+	"github.com/lukemassa/gorun/internal/server"
+)
+
+// What to do when a build fails but an earlier one succeeded, see -fallback
+const (
+	fallbackNever  = "never"
+	fallbackPrompt = "prompt"
+	fallbackAlways = "always"
+)
+
+// When gorund is not running, whether to start it, see -autostart
+const (
+	autostartNever  = "never"
+	autostartPrompt = "prompt"
+	autostartAlways = "always"
+)
+
+// options are the flags that affect gorun itself, rather than the build
+type options struct {
+	fallback  string
+	autostart string
+	supervise bool
+}
+
+// newFlagSet returns the flags for a command, whose usage is shown on -h or
+// when its arguments are wrong
+func newFlagSet(cmd command) *flag.FlagSet {
+	fs := flag.NewFlagSet("gorun "+cmd.name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gorun %s %s\n\n%s\n", cmd.name, cmd.args, cmd.summary)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// contextFlags registers the flags that pick the build context, returning a
+// function to fill in the rest of the request once they are parsed
+func contextFlags(fs *flag.FlagSet, request *server.ExecutableRequest) func() {
+	flags := &request.Flags
+	var toolchain string
+	fs.StringVar(&flags.Tags, "tags", "", "comma-separated list of build tags")
+	fs.StringVar(&flags.LDFlags, "ldflags", "", "arguments to pass on each go tool link invocation")
+	fs.StringVar(&flags.GCFlags, "gcflags", "", "arguments to pass on each go tool compile invocation")
+	fs.BoolVar(&flags.Race, "race", false, "enable data race detection")
+	fs.BoolVar(&flags.TrimPath, "trimpath", false, "remove all file system paths from the resulting executable")
+	fs.BoolVar(&flags.Cover, "cover", false, "enable code coverage instrumentation")
+	fs.StringVar(&flags.Mod, "mod", "", "module download mode to use: readonly, vendor, or mod")
+	fs.StringVar(&toolchain, "go", "", "go command to build with, instead of the daemon's")
+	return func() {
+		request.Env = os.Environ()
+		if toolchain != "" {
+			request.Toolchain = resolveToolchain(toolchain)
+		}
+	}
+}
+
+// buildFlags registers the flags that decide how to get the executable, such
+// as what to do when it needs building, returning a function to check them
+// once they are parsed
+func buildFlags(fs *flag.FlagSet, request *server.ExecutableRequest, opts *options) func() {
+	project := projectSettings()
+	fs.BoolVar(&request.Stale, "stale", boolFromEnv("GORUN_STALE", project.Stale), "run the previous build straight away if a rebuild is needed, rebuilding in the background (default from GORUN_STALE, or Stale in "+config.ProjectFile+")")
+	fs.BoolVar(&request.Retry, "retry", false, "build again even if the sources are unchanged since the last build failed")
+	fs.StringVar(&opts.fallback, "fallback", cmp.Or(os.Getenv("GORUN_FALLBACK"), fallbackNever), "when a build fails, whether to run the last successful one: never, prompt, or always (default from GORUN_FALLBACK)")
+	fs.StringVar(&opts.autostart, "autostart", cmp.Or(os.Getenv("GORUN_AUTOSTART"), autostartPrompt), "when gorund is not running, whether to start it: never, prompt, which only asks on a terminal, or always (default from GORUN_AUTOSTART)")
+	return func() {
+		switch opts.autostart {
+		case autostartNever, autostartPrompt, autostartAlways:
+		default:
+			log.Fatalf("Invalid -autostart %q, expected never, prompt, or always", opts.autostart)
+		}
+		switch opts.fallback {
+		case fallbackNever, fallbackPrompt, fallbackAlways:
+		default:
+			log.Fatalf("Invalid -fallback %q, expected never, prompt, or always", opts.fallback)
+		}
+		request.Fallback = opts.fallback != fallbackNever
+	}
+}
+
+// boolFromEnv is the value of a boolean environment variable, or fallback if
+// it is not set
+func boolFromEnv(name string, fallback bool) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("Invalid %s %q, expected true or false", name, value)
+	}
+	return b
+}
+
+// projectSettings reads the settings of the module in the current directory
+func projectSettings() config.Project {
+	dir, err := os.Getwd()
+	if err != nil {
+		return config.Project{}
+	}
+	project, err := config.ProjectFromDir(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return project
+}
+
+// parsePackage parses the flags in args, which end at the package or at a
+// "--" before it, returning the package and the arguments after it. Those are
+// passed on as they are, "--" included.
+func parsePackage(fs *flag.FlagSet, args []string) (string, []string) {
+	// ExitOnError means this never returns an error
+	_ = fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	return fs.Arg(0), fs.Args()[1:]
+}
+
+// parseNoArgs parses flags in args, which must not have any other arguments
+func parseNoArgs(fs *flag.FlagSet, args []string) {
+	_ = fs.Parse(args)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+}
+
+// resolveToolchain finds the absolute path of the go command, since the
+// daemon has a different working directory and PATH
+func resolveToolchain(toolchain string) string {
+	path, err := exec.LookPath(toolchain)
+	if err != nil {
+		log.Fatalf("Invalid -go: %v", err)
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		log.Fatalf("Invalid -go: %v", err)
+	}
+	return path
+}
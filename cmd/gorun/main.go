@@ -2,24 +2,22 @@ package main
 
 import (
 	"bufio"
-	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"strings"
-	"syscall"
-	"time"
 
 	log "github.com/lukemassa/clilog"
 	"github.com/lukemassa/gorun/internal/client"
 	"github.com/lukemassa/gorun/internal/config"
+	"github.com/lukemassa/gorun/internal/server"
 )
 
 func promptYesNo(prompt string) bool {
 	reader := bufio.NewReader(os.Stdin)
 
 	for {
-		fmt.Printf("%s [y/n]: ", prompt)
+		fmt.Fprintf(os.Stderr, "%s [y/n]: ", prompt)
 
 		line, err := reader.ReadString('\n')
 		if err != nil {
@@ -33,35 +31,75 @@ func promptYesNo(prompt string) bool {
 		case "n", "no":
 			return false
 		default:
-			fmt.Println("Please answer y or n.")
+			fmt.Fprintln(os.Stderr, "Please answer y or n.")
 		}
 	}
 }
 
-func getExecutable(c *client.Client, mainPackage string, env []string) string {
-	executable, err := c.GetCommand(mainPackage, env)
+func getExecutable(c *client.Client, request server.ExecutableRequest, opts options) server.ExecutableResponse {
+	response, err := getCommand(c, request)
+	if daemonDown(err) && startDaemon(opts.autostart) {
+		response, err = getCommand(c, request)
+	}
+	if daemonDown(err) {
+		log.Fatal("gorund is not running")
+	}
 	if err != nil {
-		if !errors.Is(err, syscall.ECONNREFUSED) {
-			log.Fatal(err)
+		if response.Fallback == "" || !useFallback(err, opts.fallback) {
+			fatal(err)
 		}
-		log.Warn("Gorun appears to not be running")
-		if !promptYesNo("Start up gorund?") {
-			log.Fatal("Exiting")
-		}
-		cmd := exec.Command("gorund", "start")
-		err := cmd.Run()
-		if err != nil {
-			log.Fatal(err)
+		response.Executable = response.Fallback
+	}
+	return response
+}
+
+// startDaemon starts gorund according to the -autostart mode, reporting
+// whether it did. gorund start returns once the daemon is serving.
+func startDaemon(autostart string) bool {
+	switch autostart {
+	case autostartNever:
+		return false
+	case autostartPrompt:
+		// Scripts have nobody to answer
+		if !isTerminal(os.Stdin) || !isTerminal(os.Stderr) {
+			log.Warn("Not asking to start gorund without a terminal, set GORUN_AUTOSTART=always to start it automatically")
+			return false
 		}
-		time.Sleep(100 * time.Millisecond)
-		log.Warn("Started up gorun")
-		executable, err = c.GetCommand(mainPackage, env)
-		if err != nil {
-			log.Fatal(err)
+		if !promptYesNo("gorund is not running, start it?") {
+			return false
 		}
+	}
+	cmd := exec.Command("gorund", "start")
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	if err != nil {
+		log.Fatalf("Failed to start gorund: %v", err)
+	}
+	return true
+}
 
+// getCommand asks for the executable, showing the progress of any build on a
+// terminal
+func getCommand(c *client.Client, request server.ExecutableRequest) (server.ExecutableResponse, error) {
+	p := newProgress(request.MainPackage)
+	defer p.clear()
+	return c.GetCommand(request, p.reporter())
+}
+
+// useFallback reports the failed build, then decides whether to run the last
+// good build instead according to the -fallback mode
+func useFallback(err error, fallback string) bool {
+	if !printCompileError(err) {
+		log.Error(err)
 	}
-	return executable
+	switch fallback {
+	case fallbackAlways:
+		log.Warn("Running the last successful build instead")
+		return true
+	case fallbackPrompt:
+		return promptYesNo("Run the last successful build instead?")
+	}
+	return false
 }
 
 func main() {
@@ -74,37 +112,18 @@ func main() {
 		log.SetLogLevel(log.LevelDebug)
 	}
 
-	verb := "run"
-	if os.Getenv("GORUN_DELETE") != "" {
-		verb = "delete"
+	c := client.NewClient(workingDir)
+	args := os.Args[1:]
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
 	}
-	client := client.NewClient(workingDir)
-
-	env := os.Environ()
-	if len(os.Args) < 2 {
-		log.Fatal("Expect argument for package")
-	}
-	mainPackage := os.Args[1]
-	mainArgs := os.Args[2:]
-
-	switch verb {
-	case "run":
-
-		executable := getExecutable(client, mainPackage, env)
-		args := []string{executable}
-		args = append(args, mainArgs...)
-
-		log.Debugf("Compiled context for %q to %q, passing additional args %v", mainPackage, executable, mainArgs)
-
-		err := syscall.Exec(executable, args, env)
-		if err != nil {
-			log.Fatalf("exec failed: %v", err)
-		}
-		// Unreachable
-	case "delete":
-		err := client.DeleteCommand(mainPackage, env)
-		if err != nil {
-			log.Fatalf("delete failed: %v", err)
-		}
+	cmd, ok := findCommand(args[0])
+	if ok {
+		args = args[1:]
+	} else {
+		// gorun <package> is short for gorun run <package>
+		cmd, _ = findCommand("run")
 	}
+	cmd.run(c, args)
 }
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lukemassa/gorun/internal/build"
+)
+
+// How often the progress indicator is redrawn, to keep the elapsed time current
+const progressInterval = 100 * time.Millisecond
+
+// The longest line of build output the progress indicator shows
+const progressMaxLine = 60
+
+var spinner = []rune(`|/-\`)
+
+// progress shows a build's progress on a single line of a terminal, erasing
+// it when the build is done. A nil *progress shows nothing.
+type progress struct {
+	w           io.Writer
+	mainPackage string
+	start       time.Time
+
+	mu     sync.Mutex
+	status string
+	frame  int
+	shown  bool
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// newProgress returns an indicator for the build of mainPackage, or nil if
+// stderr is not a terminal
+func newProgress(mainPackage string) *progress {
+	if !isTerminal(os.Stderr) || os.Getenv("TERM") == "dumb" {
+		return nil
+	}
+	return &progress{
+		w:           os.Stderr,
+		mainPackage: mainPackage,
+		start:       time.Now(),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// reporter returns the function to pass build events to, nil if p is
+func (p *progress) reporter() func(build.Event) {
+	if p == nil {
+		return nil
+	}
+	return p.report
+}
+
+func (p *progress) report(event build.Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch event.Kind {
+	case build.EventQueued:
+		p.status = fmt.Sprintf("queued at position %d", event.QueuePosition)
+	case build.EventBuilding:
+		p.status = "building"
+	case build.EventOutput:
+		p.status = "building: " + truncate(event.Line, progressMaxLine)
+	default:
+		return
+	}
+	if !p.shown {
+		// Nothing is shown for builds that don't need to wait
+		p.shown = true
+		go p.tick()
+	}
+	p.draw()
+}
+
+// tick redraws until clear is called
+func (p *progress) tick() {
+	defer close(p.done)
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.mu.Lock()
+			p.frame++
+			p.draw()
+			p.mu.Unlock()
+		}
+	}
+}
+
+// draw replaces the line, requires p.mu
+func (p *progress) draw() {
+	elapsed := time.Since(p.start).Truncate(100 * time.Millisecond)
+	fmt.Fprintf(p.w, "\r\033[K%c %s %s (%s)", spinner[p.frame%len(spinner)], p.mainPackage, p.status, elapsed)
+}
+
+// clear erases the indicator, if it was shown
+func (p *progress) clear() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	shown := p.shown
+	p.mu.Unlock()
+	if !shown {
+		return
+	}
+	close(p.stop)
+	<-p.done
+	fmt.Fprint(p.w, "\r\033[K")
+}
+
+// truncate shortens s to at most n runes, marking that it was shortened
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n-3]) + "..."
+}
@@ -0,0 +1,8 @@
+package main
+
+import "syscall"
+
+// maxRSS is the peak resident set size in bytes, which darwin reports in bytes
+func maxRSS(rusage syscall.Rusage) int64 {
+	return int64(rusage.Maxrss)
+}
@@ -0,0 +1,10 @@
+//go:build !darwin
+
+package main
+
+import "syscall"
+
+// maxRSS is the peak resident set size in bytes, which is reported in KiB
+func maxRSS(rusage syscall.Rusage) int64 {
+	return int64(rusage.Maxrss) * 1024
+}
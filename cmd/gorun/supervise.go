@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+	"unsafe"
+
+	log "github.com/lukemassa/clilog"
+	"github.com/lukemassa/gorun/internal/client"
+	"github.com/lukemassa/gorun/internal/server"
+)
+
+// Signals passed on to the supervised executable
+var forwardedSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGWINCH, syscall.SIGTSTP}
+
+// Signals that gorun can die from in turn, when they kill the executable. Go
+// handles the rest itself, so for those gorun exits the way a shell reports
+// them instead.
+var reraisedSignals = []syscall.Signal{syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL}
+
+// supervise runs the executable as a child in its own process group, rather
+// than replacing gorun with it, forwards signals to it, and exits the way it
+// did once it has told gorund how the run went, completing report
+func supervise(c *client.Client, report server.RunReport, env []string) {
+	executable := report.Executable
+	foreground := isForeground(os.Stdin)
+	attr := &syscall.SysProcAttr{Setpgid: true}
+	if foreground {
+		// So that reading the terminal doesn't stop the child, and ^C and ^Z reach it
+		attr.Foreground = true
+		attr.Ctty = 0
+	}
+	// Taking the terminal back from the child would stop gorun otherwise
+	signal.Ignore(syscall.SIGTTOU)
+	signals := make(chan os.Signal, 8)
+	signal.Notify(signals, forwardedSignals...)
+
+	startedAt := time.Now()
+	process, err := os.StartProcess(executable, append([]string{executable}, report.Args...), &os.ProcAttr{
+		Env:   env,
+		Files: []*os.File{os.Stdin, os.Stdout, os.Stderr},
+		Sys:   attr,
+	})
+	if err != nil {
+		log.Fatalf("Failed to start %s: %v", executable, err)
+	}
+	pid := process.Pid
+	go func() {
+		for sig := range signals {
+			// The child leads its process group, so this reaches anything it started too
+			_ = syscall.Kill(-pid, sig.(syscall.Signal))
+		}
+	}()
+
+	status, rusage := waitChild(pid, foreground)
+	signal.Stop(signals)
+	if foreground {
+		_ = setForeground(os.Stdin, syscall.Getpgrp())
+	}
+
+	report.StartedAt = startedAt
+	report.Supervised = true
+	report.Duration = time.Since(startedAt)
+	report.ExitCode = status.ExitStatus()
+	report.PeakRSS = maxRSS(rusage)
+	if status.Signaled() {
+		report.ExitCode = 128 + int(status.Signal())
+		report.Signal = status.Signal().String()
+	}
+	err = c.ReportRun(report)
+	if err != nil {
+		log.Debugf("Failed to report the run to gorund: %v", err)
+	}
+	exitLike(status)
+}
+
+// waitChild waits for the child to exit. If the child is suspended, gorun
+// suspends itself too, since it is what the shell sees as the job, and resumes
+// the child when it is resumed.
+func waitChild(pid int, foreground bool) (syscall.WaitStatus, syscall.Rusage) {
+	for {
+		var status syscall.WaitStatus
+		var rusage syscall.Rusage
+		_, err := syscall.Wait4(pid, &status, syscall.WUNTRACED, &rusage)
+		if errors.Is(err, syscall.EINTR) {
+			continue
+		}
+		if err != nil {
+			log.Fatalf("Failed to wait for the executable: %v", err)
+		}
+		if !status.Stopped() {
+			return status, rusage
+		}
+		if foreground {
+			_ = setForeground(os.Stdin, syscall.Getpgrp())
+		}
+		_ = syscall.Kill(syscall.Getpid(), syscall.SIGSTOP)
+		// Resumed, in the foreground by fg or in the background by bg
+		if foreground && isForeground(os.Stdin) {
+			_ = setForeground(os.Stdin, pid)
+		}
+		_ = syscall.Kill(-pid, syscall.SIGCONT)
+	}
+}
+
+// exitLike exits with the child's status, dying from the same signal if it did
+func exitLike(status syscall.WaitStatus) {
+	if status.Signaled() {
+		sig := status.Signal()
+		if slices.Contains(reraisedSignals, sig) {
+			signal.Reset(sig)
+			_ = syscall.Kill(syscall.Getpid(), sig)
+			// Give the signal a moment to arrive
+			time.Sleep(time.Second)
+		}
+		os.Exit(128 + int(sig))
+	}
+	os.Exit(status.ExitStatus())
+}
+
+// isForeground is true if f is a terminal that gorun is in the foreground of
+func isForeground(f *os.File) bool {
+	if !isTerminal(f) {
+		return false
+	}
+	pgrp, err := foregroundGroup(f)
+	return err == nil && pgrp == syscall.Getpgrp()
+}
+
+// foregroundGroup returns the process group in the foreground of the terminal f
+func foregroundGroup(f *os.File) (int, error) {
+	var pgrp int32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TIOCGPGRP, uintptr(unsafe.Pointer(&pgrp)))
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(pgrp), nil
+}
+
+// setForeground puts the process group in the foreground of the terminal f
+func setForeground(f *os.File, pgrp int) error {
+	p := int32(pgrp)
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TIOCSPGRP, uintptr(unsafe.Pointer(&p)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -1,16 +1,22 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
+	clilog "github.com/lukemassa/clilog"
+	"github.com/lukemassa/gorun/internal/cacheprog"
 	"github.com/lukemassa/gorun/internal/config"
+	"github.com/lukemassa/gorun/internal/remote"
 	"github.com/lukemassa/gorun/internal/server"
 )
 
 func usage() {
-	fmt.Fprintf(os.Stderr, "Usage: goruncd start|stop|run\n")
+	fmt.Fprintf(os.Stderr, "Usage: gorund start|stop|run|cacheprog|cachestats\n")
 	os.Exit(1)
 }
 
@@ -18,7 +24,35 @@ func main() {
 	if len(os.Args) != 2 {
 		usage()
 	}
-	s := server.NewServer(config.WorkingDir())
+	settings, err := config.DaemonFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	switch os.Args[1] {
+	case "cacheprog":
+		err = runCacheProg(settings)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "cachestats":
+		stats, err := cacheprog.ReadStats(config.GoCacheDir(config.WorkingDir()))
+		if err != nil {
+			log.Fatal(err)
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(stats)
+		return
+	}
+
+	if settings.GoCacheProg {
+		err = setGoCacheProg()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	s := server.NewServer(config.WorkingDir(), settings)
 	cmd := os.Args[1]
 	if cmd == "run" {
 		s.Run()
@@ -26,7 +60,6 @@ func main() {
 	}
 	runner := server.NewOSProcessController(os.Args[0], "run")
 	daemon := server.NewDaemon(s, runner)
-	var err error
 	switch cmd {
 	case "start":
 		err = daemon.Start()
@@ -39,3 +72,37 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// runCacheProg serves the go command's build cache on stdin and stdout
+func runCacheProg(settings config.Daemon) error {
+	// Our stderr is the go command's, so only say something when it matters
+	clilog.SetLogLevel(clilog.LevelWarn)
+	store, err := cacheprog.NewStore(config.GoCacheDir(config.WorkingDir()))
+	if err != nil {
+		return err
+	}
+	if settings.RemoteCache != "" {
+		backend, err := remote.NewBackend(settings.RemoteCache)
+		if err != nil {
+			return err
+		}
+		store.SetBackend(backend)
+	}
+	err = cacheprog.Serve(context.Background(), store, os.Stdin, os.Stdout)
+	if closeErr := store.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// setGoCacheProg makes every go command the daemon runs use `gorund cacheprog`
+func setGoCacheProg() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if strings.ContainsAny(exe, " \t") {
+		exe = "'" + exe + "'"
+	}
+	return os.Setenv("GOCACHEPROG", exe+" cacheprog")
+}
@@ -1,9 +1,18 @@
 package e2e
 
 import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"syscall"
 	"testing"
 	"testing/fstest"
+	"time"
 
+	"github.com/lukemassa/gorun/internal/server"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -57,9 +66,9 @@ func main() {
 
 	result := runCLI(t, workingDir, "main.go")
 
-	// This should still get the result of the first run, since it's still cached
+	// The source changed, so this should be rebuilt rather than served from the cache
 
-	assert.Equal(t, "Hello Gorun!\n", result.Stdout)
+	assert.Equal(t, "Something else!\n", result.Stdout)
 	assert.Equal(t, 0, result.Code)
 	assert.Contains(t, result.Stderr, "Compiled context for")
 
@@ -83,3 +92,560 @@ func main() {
 	assert.Contains(t, result.Stderr, "Compiled context for")
 
 }
+
+func TestCacheHit(t *testing.T) {
+	workingDir := t.TempDir()
+
+	writeFS(t, fstest.MapFS{
+		"main.go": &fstest.MapFile{
+			Data: []byte(`package main
+import "fmt"
+
+func main() {
+	fmt.Println("Hello Gorun!")
+}`),
+		},
+	}, workingDir)
+
+	first := runCLI(t, workingDir, "main.go")
+	second := runCLI(t, workingDir, "main.go")
+
+	assert.Equal(t, "Hello Gorun!\n", second.Stdout)
+	assert.Equal(t, 0, second.Code)
+	assert.Equal(t, compiledPath(t, first), compiledPath(t, second))
+}
+
+func TestBuildFlags(t *testing.T) {
+	workingDir := t.TempDir()
+
+	writeFS(t, fstest.MapFS{
+		"main.go": &fstest.MapFile{
+			Data: []byte(`package main
+import "fmt"
+
+var message = "default"
+
+func main() {
+	fmt.Println(message)
+}`),
+		},
+	}, workingDir)
+
+	result := runCLI(t, workingDir, "-ldflags", "-X main.message=flagged", "main.go")
+	assert.Equal(t, "flagged\n", result.Stdout)
+	assert.Equal(t, 0, result.Code)
+
+	// Differently flagged builds of the same package should not share a cache entry
+	result = runCLI(t, workingDir, "main.go")
+	assert.Equal(t, "default\n", result.Stdout)
+	assert.Equal(t, 0, result.Code)
+}
+
+func TestClientEnvironment(t *testing.T) {
+	workingDir := t.TempDir()
+
+	writeFS(t, fstest.MapFS{
+		"main.go": &fstest.MapFile{
+			Data: []byte(`package main
+import "fmt"
+
+var message = "default"
+
+func main() {
+	fmt.Println(message)
+}`),
+		},
+	}, workingDir)
+
+	result := runCLIWithEnv(t, workingDir, []string{"GOFLAGS=-ldflags=-X=main.message=fromenv"}, "main.go")
+	assert.Equal(t, "fromenv\n", result.Stdout)
+	assert.Equal(t, 0, result.Code)
+
+	// A caller with a different environment should get its own build
+	result = runCLI(t, workingDir, "main.go")
+	assert.Equal(t, "default\n", result.Stdout)
+	assert.Equal(t, 0, result.Code)
+}
+
+func TestSharedAcrossModule(t *testing.T) {
+	workingDir := t.TempDir()
+
+	writeFS(t, fstest.MapFS{
+		"go.mod": &fstest.MapFile{
+			Data: []byte("module example.com/shared\n\ngo 1.22\n"),
+		},
+		"cmd/tool/main.go": &fstest.MapFile{
+			Data: []byte(`package main
+import "fmt"
+
+func main() {
+	fmt.Println("Hello from tool!")
+}`),
+		},
+	}, workingDir)
+
+	fromRoot := runCLI(t, workingDir, "./cmd/tool")
+	assert.Equal(t, "Hello from tool!\n", fromRoot.Stdout)
+	assert.Equal(t, 0, fromRoot.Code)
+
+	fromPackage := runCLI(t, filepath.Join(workingDir, "cmd", "tool"), ".")
+	assert.Equal(t, "Hello from tool!\n", fromPackage.Stdout)
+	assert.Equal(t, 0, fromPackage.Code)
+
+	assert.Equal(t, compiledPath(t, fromRoot), compiledPath(t, fromPackage))
+}
+
+func TestRemoteModule(t *testing.T) {
+	workingDir := t.TempDir()
+	proxyDir := t.TempDir()
+
+	for _, version := range []string{"v1.0.0", "v1.1.0"} {
+		writeModuleProxy(t, proxyDir, "example.com/greeter", version, map[string]string{
+			"go.mod": "module example.com/greeter\n\ngo 1.22\n",
+			"cmd/greet/main.go": `package main
+import "fmt"
+
+func main() {
+	fmt.Println("Hello from ` + version + `!")
+}`,
+		})
+	}
+	env := []string{
+		"GOPROXY=file://" + filepath.ToSlash(proxyDir),
+		"GOSUMDB=off",
+		"GOMODCACHE=" + t.TempDir(),
+		"GOFLAGS=-modcacherw",
+	}
+
+	pinned := runCLIWithEnv(t, workingDir, env, "example.com/greeter/cmd/greet@v1.0.0")
+	assert.Equal(t, "Hello from v1.0.0!\n", pinned.Stdout)
+	assert.Equal(t, 0, pinned.Code)
+
+	latest := runCLIWithEnv(t, workingDir, env, "example.com/greeter/cmd/greet@latest")
+	assert.Equal(t, "Hello from v1.1.0!\n", latest.Stdout)
+	assert.Equal(t, 0, latest.Code)
+
+	// @latest resolves to v1.1.0, so they share a cache entry
+	exact := runCLIWithEnv(t, workingDir, env, "example.com/greeter/cmd/greet@v1.1.0")
+	assert.Equal(t, "Hello from v1.1.0!\n", exact.Stdout)
+	assert.Equal(t, compiledPath(t, latest), compiledPath(t, exact))
+}
+
+func TestExplicitToolchain(t *testing.T) {
+	workingDir := t.TempDir()
+
+	writeFS(t, fstest.MapFS{
+		"main.go": &fstest.MapFile{
+			Data: []byte(`package main
+import "fmt"
+
+func main() {
+	fmt.Println("Hello Gorun!")
+}`),
+		},
+	}, workingDir)
+
+	realGo, err := exec.LookPath("go")
+	assert.NoError(t, err)
+	toolchain := filepath.Join(t.TempDir(), "go")
+	err = os.WriteFile(toolchain, []byte("#!/bin/sh\nexec "+realGo+" \"$@\"\n"), 0o755)
+	assert.NoError(t, err)
+
+	defaultToolchain := runCLI(t, workingDir, "main.go")
+	assert.Equal(t, 0, defaultToolchain.Code)
+
+	explicit := runCLI(t, workingDir, "-go="+toolchain, "main.go")
+	assert.Equal(t, "Hello Gorun!\n", explicit.Stdout)
+	assert.Equal(t, 0, explicit.Code)
+	assert.NotEqual(t, compiledPath(t, defaultToolchain), compiledPath(t, explicit))
+}
+
+func TestCompileError(t *testing.T) {
+	workingDir := t.TempDir()
+
+	writeFS(t, fstest.MapFS{
+		"main.go": &fstest.MapFile{
+			Data: []byte(`package main
+
+func main() {
+	undefinedFunction()
+}`),
+		},
+	}, workingDir)
+
+	result := runCLI(t, workingDir, "main.go")
+
+	assert.Equal(t, 3, result.Code)
+	assert.Equal(t, "", result.Stdout)
+	assert.Contains(t, result.Stderr, "# command-line-arguments\n./main.go:4:2: undefined: undefinedFunction\n")
+}
+
+func TestStale(t *testing.T) {
+	workingDir := t.TempDir()
+
+	writeFS(t, fstest.MapFS{
+		"main.go": &fstest.MapFile{
+			Data: []byte(`package main
+import "fmt"
+
+func main() {
+	fmt.Println("Hello stale!")
+}`),
+		},
+	}, workingDir)
+
+	result := runCLI(t, workingDir, "-stale", "main.go")
+	// Nothing to fall back on, so the first build is waited for
+	assert.Equal(t, "Hello stale!\n", result.Stdout)
+	assert.NotContains(t, result.Stderr, "stale")
+
+	writeFS(t, fstest.MapFS{
+		"main.go": &fstest.MapFile{
+			Data: []byte(`package main
+import "fmt"
+
+func main() {
+	fmt.Println("Hello fresh!")
+}`),
+		},
+	}, workingDir)
+
+	result = runCLIWithEnv(t, workingDir, []string{"GORUN_STALE=1"}, "main.go")
+	assert.Equal(t, "Hello stale!\n", result.Stdout)
+	assert.Equal(t, 0, result.Code)
+	assert.Contains(t, result.Stderr, "Running a stale build of main.go")
+
+	// The rebuild carries on in the background
+	assert.Eventually(t, func() bool {
+		result := runCLI(t, workingDir, "-stale", "main.go")
+		return result.Stdout == "Hello fresh!\n"
+	}, 5*time.Second, 100*time.Millisecond)
+}
+
+func TestFallback(t *testing.T) {
+	workingDir := t.TempDir()
+
+	writeFS(t, fstest.MapFS{
+		"main.go": &fstest.MapFile{
+			Data: []byte(`package main
+import "fmt"
+
+func main() {
+	fmt.Println("Hello fallback!")
+}`),
+		},
+	}, workingDir)
+
+	result := runCLI(t, workingDir, "main.go")
+	assert.Equal(t, "Hello fallback!\n", result.Stdout)
+
+	writeFS(t, fstest.MapFS{
+		"main.go": &fstest.MapFile{
+			Data: []byte(`package main
+
+func main() {
+	undefinedFunction()
+}`),
+		},
+	}, workingDir)
+
+	// Off by default
+	result = runCLI(t, workingDir, "main.go")
+	assert.Equal(t, 3, result.Code)
+	assert.Equal(t, "", result.Stdout)
+
+	result = runCLIWithEnv(t, workingDir, []string{"GORUN_FALLBACK=always"}, "main.go")
+	assert.Equal(t, 0, result.Code)
+	assert.Equal(t, "Hello fallback!\n", result.Stdout)
+	assert.Contains(t, result.Stderr, "./main.go:4:2: undefined: undefinedFunction\n")
+	assert.Contains(t, result.Stderr, "Running the last successful build instead")
+}
+
+func TestSharedAcrossCheckouts(t *testing.T) {
+	checkout := fstest.MapFS{
+		"go.mod": &fstest.MapFile{Data: []byte("module example.com/checkout\n\ngo 1.22\n")},
+		"main.go": &fstest.MapFile{
+			Data: []byte(`package main
+import "fmt"
+
+func main() {
+	fmt.Println("Hello checkout!")
+}`),
+		},
+	}
+	var paths []string
+	for range 2 {
+		workingDir := t.TempDir()
+		writeFS(t, checkout, workingDir)
+		// Without -trimpath, each binary would record its own checkout
+		result := runCLI(t, workingDir, "run", "-trimpath", ".")
+		assert.Equal(t, "Hello checkout!\n", result.Stdout)
+		paths = append(paths, compiledPath(t, result))
+	}
+
+	// Each checkout has its own entry, sharing one binary
+	assert.NotEqual(t, paths[0], paths[1])
+	first, err := os.Stat(paths[0])
+	assert.NoError(t, err)
+	second, err := os.Stat(paths[1])
+	assert.NoError(t, err)
+	assert.True(t, os.SameFile(first, second))
+}
+
+func TestCommands(t *testing.T) {
+	workingDir := t.TempDir()
+
+	writeFS(t, fstest.MapFS{
+		"main.go": &fstest.MapFile{
+			Data: []byte(`package main
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	fmt.Println("Hello commands!", os.Args[1:])
+}`),
+		},
+	}, workingDir)
+
+	result := runCLI(t, workingDir, "run", "-race", "--", "main.go", "-race", "--")
+	assert.Equal(t, "Hello commands! [-race --]\n", result.Stdout)
+	assert.Equal(t, 0, result.Code)
+	executable := compiledPath(t, result)
+
+	// Everything after the package is the program's, as with the shorthand
+	result = runCLI(t, workingDir, "run", "-race", "main.go", "--", "-race")
+	assert.Equal(t, "Hello commands! [-- -race]\n", result.Stdout)
+	shorthand := runCLI(t, workingDir, "-race", "main.go", "--", "-race")
+	assert.Equal(t, result.Stdout, shorthand.Stdout)
+
+	result = runCLI(t, workingDir, "which", "-race", "main.go")
+	assert.Equal(t, executable+"\n", result.Stdout)
+
+	result = runCLI(t, workingDir, "which", "-json", "-race", "main.go")
+	var which struct {
+		Executable  string
+		MainPackage string
+		Directory   string
+		Size        int64
+		BuiltAt     time.Time
+	}
+	assert.NoError(t, json.Unmarshal([]byte(result.Stdout), &which))
+	assert.Equal(t, executable, which.Executable)
+	assert.Equal(t, "main.go", which.MainPackage)
+	assert.Equal(t, workingDir, which.Directory)
+	assert.Positive(t, which.Size)
+	assert.False(t, which.BuiltAt.IsZero())
+
+	result = runCLI(t, workingDir, "list")
+	assert.Contains(t, result.Stdout, executable)
+	assert.Contains(t, result.Stdout, "fresh")
+
+	result = runCLI(t, workingDir, "list", "-json")
+	var commands []server.CommandInfo
+	assert.NoError(t, json.Unmarshal([]byte(result.Stdout), &commands))
+	i := slices.IndexFunc(commands, func(c server.CommandInfo) bool { return c.Executable == executable })
+	if assert.NotEqual(t, -1, i) {
+		assert.Equal(t, "./main.go", commands[i].MainPackage)
+		assert.True(t, commands[i].Flags.Race)
+		assert.Positive(t, commands[i].Size)
+		assert.Equal(t, 4, commands[i].Hits)
+		assert.False(t, commands[i].Stale)
+	}
+
+	result = runCLI(t, workingDir, "evict", "-race", "main.go")
+	assert.Equal(t, 0, result.Code)
+	assert.NoFileExists(t, executable)
+	result = runCLI(t, workingDir, "list")
+	assert.NotContains(t, result.Stdout, executable)
+
+	result = runCLI(t, workingDir, "status")
+	assert.Contains(t, result.Stdout, "gorund is running")
+
+	result = runCLI(t, workingDir, "bogus-package.go")
+	assert.NotEqual(t, 0, result.Code)
+}
+
+func TestSupervise(t *testing.T) {
+	workingDir := t.TempDir()
+
+	writeFS(t, fstest.MapFS{
+		"main.go": &fstest.MapFile{
+			Data: []byte(`package main
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	switch os.Args[1] {
+	case "exit":
+		os.Exit(3)
+	case "die":
+		syscall.Kill(os.Getpid(), syscall.SIGTERM)
+		select {}
+	case "wait":
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM)
+		fmt.Println("Supervised and waiting")
+		<-signals
+		fmt.Println("Got terminated")
+		os.Exit(7)
+	}
+}`),
+		},
+	}, workingDir)
+	env := []string{"GORUN_SUPERVISE=1"}
+
+	result := runCLIWithEnv(t, workingDir, env, "main.go", "exit")
+	assert.Equal(t, 3, result.Code)
+
+	// Supervision is off when set to false, so only the start is recorded
+	result = runCLIWithEnv(t, workingDir, []string{"GORUN_SUPERVISE=0"}, "main.go", "exit")
+	assert.Equal(t, 3, result.Code)
+	assert.Eventually(t, func() bool {
+		var runs []server.RunReport
+		result := runCLI(t, workingDir, "history", "-dir", ".", "-n", "1", "-json")
+		return json.Unmarshal([]byte(result.Stdout), &runs) == nil && len(runs) == 1 && !runs[0].Supervised
+	}, 5*time.Second, 50*time.Millisecond)
+
+	cmd := exec.Command(cliPath, "main.go", "die")
+	cmd.Dir = workingDir
+	cmd.Env = []string{"GORUN_WORKING_DIR=" + gorunWorkingDir, "PWD=" + workingDir, "GORUN_SUPERVISE=1"}
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if assert.ErrorAs(t, err, &exitErr) {
+		status := exitErr.Sys().(syscall.WaitStatus)
+		assert.True(t, status.Signaled())
+		assert.Equal(t, syscall.SIGTERM, status.Signal())
+	}
+
+	cmd = exec.Command(cliPath, "main.go", "wait")
+	cmd.Dir = workingDir
+	cmd.Env = []string{"GORUN_WORKING_DIR=" + gorunWorkingDir, "PWD=" + workingDir, "GORUN_SUPERVISE=1"}
+	stdout, err := cmd.StdoutPipe()
+	assert.NoError(t, err)
+	assert.NoError(t, cmd.Start())
+	reader := bufio.NewReader(stdout)
+	line, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "Supervised and waiting\n", line)
+	assert.NoError(t, cmd.Process.Signal(syscall.SIGTERM))
+	line, err = reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "Got terminated\n", line)
+	err = cmd.Wait()
+	if assert.ErrorAs(t, err, &exitErr) {
+		assert.Equal(t, 7, exitErr.ExitCode())
+	}
+}
+
+func TestHistory(t *testing.T) {
+	workingDir := t.TempDir()
+
+	writeFS(t, fstest.MapFS{
+		"go.mod": &fstest.MapFile{
+			Data: []byte("module example.com/history\n\ngo 1.22\n"),
+		},
+		"main.go": &fstest.MapFile{
+			Data: []byte(`package main
+import "os"
+
+func main() {
+	if os.Args[1] == "fail" {
+		os.Exit(1)
+	}
+}`),
+		},
+	}, workingDir)
+	env := []string{"GORUN_SUPERVISE=1"}
+
+	runCLIWithEnv(t, workingDir, env, ".", "ok")
+	runCLIWithEnv(t, workingDir, env, ".", "fail")
+
+	// Runs are recorded by the package they resolved to
+	var runs []server.RunReport
+	result := runCLI(t, workingDir, "history", "-package", "example.com/history", "-dir", ".", "-json")
+	assert.Equal(t, 0, result.Code, result.Stderr)
+	assert.NoError(t, json.Unmarshal([]byte(result.Stdout), &runs))
+	if assert.Len(t, runs, 2) {
+		assert.Equal(t, "example.com/history", runs[0].MainPackage)
+		assert.Equal(t, []string{"fail"}, runs[0].Args)
+		assert.Equal(t, 1, runs[0].ExitCode)
+		assert.Equal(t, []string{"ok"}, runs[1].Args)
+		assert.Equal(t, 0, runs[1].ExitCode)
+		assert.NotEmpty(t, runs[1].BuildID)
+		assert.Equal(t, runs[0].BuildID, runs[1].BuildID)
+	}
+
+	result = runCLI(t, workingDir, "history", "-package", "example.com/history", "-status", "ok", "-json")
+	assert.NoError(t, json.Unmarshal([]byte(result.Stdout), &runs))
+	if assert.Len(t, runs, 1) {
+		assert.Equal(t, []string{"ok"}, runs[0].Args)
+	}
+
+	// Without -supervise, only the start of the run is recorded
+	runCLI(t, workingDir, ".", "ok")
+	assert.Eventually(t, func() bool {
+		result := runCLI(t, workingDir, "history", "-package", "example.com/history", "-n", "1", "-json")
+		return json.Unmarshal([]byte(result.Stdout), &runs) == nil && len(runs) == 1 && !runs[0].Supervised
+	}, 5*time.Second, 50*time.Millisecond)
+
+	result = runCLI(t, workingDir, "history", "-package", "example.com/history")
+	assert.Equal(t, 0, result.Code, result.Stderr)
+	assert.Contains(t, result.Stdout, "STARTED")
+	assert.Contains(t, result.Stdout, "fail")
+}
+
+func TestStaleProjectSetting(t *testing.T) {
+	workingDir := t.TempDir()
+
+	writeFS(t, fstest.MapFS{
+		"go.mod": &fstest.MapFile{
+			Data: []byte("module example.com/stale\n\ngo 1.22\n"),
+		},
+		".gorun.json": &fstest.MapFile{
+			Data: []byte(`{"Stale": true}`),
+		},
+		"cmd/tool/main.go": &fstest.MapFile{
+			Data: []byte(`package main
+import "fmt"
+
+func main() {
+	fmt.Println("Hello stale!")
+}`),
+		},
+	}, workingDir)
+	toolDir := filepath.Join(workingDir, "cmd", "tool")
+
+	result := runCLI(t, toolDir, ".")
+	assert.Equal(t, "Hello stale!\n", result.Stdout)
+
+	writeFS(t, fstest.MapFS{
+		"cmd/tool/main.go": &fstest.MapFile{
+			Data: []byte(`package main
+import "fmt"
+
+func main() {
+	fmt.Println("Hello fresh!")
+}`),
+		},
+	}, workingDir)
+
+	result = runCLI(t, toolDir, ".")
+	assert.Equal(t, "Hello stale!\n", result.Stdout)
+	assert.Contains(t, result.Stderr, "Running a stale build of .")
+
+	// The environment overrides the project, and the flag overrides both
+	result = runCLIWithEnv(t, toolDir, []string{"GORUN_STALE=false"}, ".")
+	assert.Equal(t, "Hello fresh!\n", result.Stdout)
+	result = runCLIWithEnv(t, toolDir, []string{"GORUN_STALE=true"}, "-stale=false", ".")
+	assert.Equal(t, "Hello fresh!\n", result.Stdout)
+
+	result = runCLIWithEnv(t, toolDir, []string{"GORUN_STALE=sometimes"}, ".")
+	assert.NotEqual(t, 0, result.Code)
+	assert.Contains(t, result.Stderr, "Invalid GORUN_STALE")
+}
@@ -1,6 +1,7 @@
 package e2e
 
 import (
+	"archive/zip"
 	"bytes"
 	"context"
 	"errors"
@@ -9,9 +10,11 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"testing"
 	"time"
 
+	"github.com/lukemassa/gorun/internal/config"
 	"github.com/lukemassa/gorun/internal/server"
 )
 
@@ -20,6 +23,8 @@ var (
 	gorunWorkingDir string
 )
 
+var compiledPathRegexp = regexp.MustCompile(`Compiled context for "[^"]*" to "([^"]*)"`)
+
 func TestMain(m *testing.M) {
 	dir, err := os.MkdirTemp("", "gorun-test-e2e-*")
 	if err != nil {
@@ -38,7 +43,7 @@ func TestMain(m *testing.M) {
 	}
 	gorunWorkingDir = dir
 
-	server := server.NewServer(dir)
+	server := server.NewServer(dir, config.Daemon{HistorySize: 100})
 	cancel, err := server.Start()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to startz test server: %s\n%s", err, out)
@@ -59,6 +64,12 @@ type RunResult struct {
 // runCLI runs the CLI with the args, in a directory with the files from fsys
 // If the command times out, the code is set to -1
 func runCLI(t *testing.T, workingDir string, args ...string) RunResult {
+	t.Helper()
+	return runCLIWithEnv(t, workingDir, nil, args...)
+}
+
+// runCLIWithEnv is like runCLI, but with additional environment variables
+func runCLIWithEnv(t *testing.T, workingDir string, env []string, args ...string) RunResult {
 	t.Helper()
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -71,6 +82,7 @@ func runCLI(t *testing.T, workingDir string, args ...string) RunResult {
 	cmd.Env = append(cmd.Env, fmt.Sprintf("GORUN_WORKING_DIR=%s", gorunWorkingDir))
 	cmd.Env = append(cmd.Env, fmt.Sprintf("PWD=%s", workingDir))
 	cmd.Env = append(cmd.Env, "GORUN_DEBUG=1")
+	cmd.Env = append(cmd.Env, env...)
 
 	err := cmd.Run()
 
@@ -122,3 +134,62 @@ func writeFS(t *testing.T, src fs.FS, dst string) {
 		t.Fatalf("WriteFS failed populating %q: %v", dst, err)
 	}
 }
+
+// compiledPath extracts the executable gorun ran from its debug output
+func compiledPath(t *testing.T, result RunResult) string {
+	t.Helper()
+	match := compiledPathRegexp.FindStringSubmatch(result.Stderr)
+	if match == nil {
+		t.Fatalf("no compiled path in output %q", result.Stderr)
+	}
+	return match[1]
+}
+
+// writeModuleProxy adds a module version to a directory that can be used with
+// GOPROXY=file://dir, see https://go.dev/ref/mod#goproxy-protocol
+func writeModuleProxy(t *testing.T, proxyDir, modulePath, version string, files map[string]string) {
+	t.Helper()
+	dir := filepath.Join(proxyDir, modulePath, "@v")
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := os.OpenFile(filepath.Join(dir, "list"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer list.Close()
+	fmt.Fprintln(list, version)
+
+	info := fmt.Sprintf(`{"Version":%q,"Time":"2024-01-01T00:00:00Z"}`, version)
+	err = os.WriteFile(filepath.Join(dir, version+".info"), []byte(info), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, version+".mod"), []byte(files["go.mod"]), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(modulePath + "@" + version + "/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = w.Write([]byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = zw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, version+".zip"), buf.Bytes(), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
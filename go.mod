@@ -2,12 +2,15 @@ module github.com/lukemassa/gorun
 
 go 1.25.3
 
+require (
+	github.com/lukemassa/clilog v0.1.2
+	github.com/stretchr/testify v1.11.1
+	github.com/zeebo/xxh3 v1.0.2
+)
+
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/klauspost/cpuid/v2 v2.0.9 // indirect
-	github.com/lukemassa/clilog v0.1.2 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/stretchr/testify v1.11.1 // indirect
-	github.com/zeebo/xxh3 v1.0.2 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,100 @@
+package build
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/lukemassa/clilog"
+)
+
+// Backend is storage for binaries beyond this machine, such as a cache shared
+// with CI and teammates, keyed by input hash
+type Backend interface {
+	// Get downloads the binary built from the inputs to path, returning
+	// ErrNotInBackend if there is none
+	Get(ctx context.Context, inputs, path string) error
+	// Put uploads the binary at path, built from the inputs
+	Put(ctx context.Context, inputs, path string, metadata Metadata) error
+}
+
+// ErrNotInBackend is returned by a Backend that has no binary for the inputs
+var ErrNotInBackend = errors.New("not in backend")
+
+// Metadata describes a binary stored in a Backend
+type Metadata struct {
+	MainPackage string
+	GoVersion   string
+	GOOS        string
+	GOARCH      string
+	BuiltAt     time.Time
+}
+
+// How long an upload may take before it is abandoned
+const uploadTimeout = 10 * time.Minute
+
+// SetBackend shares binaries through b, downloading any binary it has instead
+// of compiling, and uploading every binary compiled
+func (s *Cache) SetBackend(b Backend) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.backend = b
+}
+
+func (s *Cache) getBackend() Backend {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.backend
+}
+
+// download fetches the binary built from the inputs to path, returning
+// whether it did so
+func (s *Cache) download(ctx context.Context, inputs, path string) bool {
+	backend := s.getBackend()
+	if backend == nil {
+		return false
+	}
+	err := backend.Get(ctx, inputs, path)
+	if errors.Is(err, ErrNotInBackend) {
+		return false
+	}
+	if err != nil {
+		log.Warnf("Failed to download %s, compiling instead: %v", inputs, err)
+		_ = os.Remove(path)
+		return false
+	}
+	err = os.Chmod(path, 0700)
+	if err != nil {
+		log.Warnf("Failed to make %s executable, compiling instead: %v", path, err)
+		_ = os.Remove(path)
+		return false
+	}
+	log.Infof("Downloaded %s from the backend", filepath.Base(path))
+	return true
+}
+
+// upload shares the binary at path in the background
+func (s *Cache) upload(executableContext Context, inputs, path string) {
+	backend := s.getBackend()
+	if backend == nil {
+		return
+	}
+	goos, goarch := executableContext.platform()
+	metadata := Metadata{
+		MainPackage: executableContext.MainPackage,
+		GoVersion:   executableContext.GoVersion,
+		GOOS:        goos,
+		GOARCH:      goarch,
+		BuiltAt:     time.Now(),
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), uploadTimeout)
+		defer cancel()
+		err := backend.Put(ctx, inputs, path, metadata)
+		if err != nil {
+			log.Warnf("Failed to upload %s: %v", path, err)
+		}
+	}()
+}
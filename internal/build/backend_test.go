@@ -0,0 +1,98 @@
+package build
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mockBackend stores binaries in memory
+type mockBackend struct {
+	mu       sync.Mutex
+	binaries map[string][]byte
+	metadata map[string]Metadata
+}
+
+func newMockBackend() *mockBackend {
+	return &mockBackend{
+		binaries: make(map[string][]byte),
+		metadata: make(map[string]Metadata),
+	}
+}
+
+func (m *mockBackend) Get(ctx context.Context, inputs, path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	content, ok := m.binaries[inputs]
+	if !ok {
+		return ErrNotInBackend
+	}
+	return os.WriteFile(path, content, 0o600)
+}
+
+func (m *mockBackend) Put(ctx context.Context, inputs, path string, metadata Metadata) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.binaries[inputs] = content
+	m.metadata[inputs] = metadata
+	return nil
+}
+
+func (m *mockBackend) len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.binaries)
+}
+
+func TestBackendSharesBuilds(t *testing.T) {
+	backend := newMockBackend()
+	c := Context{MainPackage: "example.com/tool"}
+
+	ci := NewCache(t.TempDir(), newMockCompiler())
+	ci.SetBackend(backend)
+	_, err := ci.GetExecutableFromContext(context.Background(), c)
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool { return backend.len() == 1 }, time.Second, time.Millisecond)
+	for _, metadata := range backend.metadata {
+		assert.Equal(t, "example.com/tool", metadata.MainPackage)
+	}
+
+	compiler := newMockCompiler()
+	developer := NewCache(t.TempDir(), compiler)
+	developer.SetBackend(backend)
+	path, err := developer.GetExecutableFromContext(context.Background(), c)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, compiler.compiles)
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o700), info.Mode().Perm())
+
+	// A forced rebuild doesn't trust the backend
+	assert.NoError(t, developer.Recompile(context.Background(), c, Interactive))
+	assert.Equal(t, 1, compiler.compiles)
+}
+
+func TestBackendMetadataUsesTargetPlatform(t *testing.T) {
+	backend := newMockBackend()
+	c := Context{MainPackage: "example.com/tool", Env: []string{"GOARCH=arm64", "GOOS=windows"}}
+
+	cache := NewCache(t.TempDir(), newMockCompiler())
+	cache.SetBackend(backend)
+	_, err := cache.GetExecutableFromContext(context.Background(), c)
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool { return backend.len() == 1 }, time.Second, time.Millisecond)
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	for _, metadata := range backend.metadata {
+		assert.Equal(t, "windows", metadata.GOOS)
+		assert.Equal(t, "arm64", metadata.GOARCH)
+	}
+}
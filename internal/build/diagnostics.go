@@ -0,0 +1,89 @@
+package build
+
+import (
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Diagnostic is a single problem reported by the go command
+type Diagnostic struct {
+	// Package is the import path of the package being built, if known
+	Package string
+	// File is the absolute path of the file with the problem, if any
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+// CompileError is returned when the go command fails to build an executable
+type CompileError struct {
+	Output      string
+	Diagnostics []Diagnostic
+}
+
+func (c *CompileError) Error() string {
+	return c.Output
+}
+
+// fromSources reports whether the diagnostics are down to the sources in dir.
+// That is so if any come from compiling or linking a package, which the go
+// command heads with "# package", or if every one points into dir, as when a
+// file fails to parse while loading packages. Anything else is the go command
+// failing for its own reasons, say to find a module.
+func fromSources(diagnostics []Diagnostic, dir string) bool {
+	inDir := len(diagnostics) > 0
+	for _, d := range diagnostics {
+		if d.Package != "" {
+			return true
+		}
+		if rel, err := filepath.Rel(dir, d.File); d.File == "" || err != nil || !filepath.IsLocal(rel) {
+			inDir = false
+		}
+	}
+	return inDir
+}
+
+// diagnosticLine matches lines such as "./main.go:5:2: undefined: foo"
+var diagnosticLine = regexp.MustCompile(`^(\S+?\.\w+):(\d+)(?::(\d+))?: (.*)$`)
+
+// parseDiagnostics turns the output of `go build`, run in dir, into diagnostics
+func parseDiagnostics(output string, dir string) []Diagnostic {
+	var diagnostics []Diagnostic
+	var pkg string
+	for _, line := range strings.Split(output, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "# ") {
+			pkg = strings.TrimPrefix(line, "# ")
+			continue
+		}
+		if strings.HasPrefix(line, "\t") && len(diagnostics) > 0 {
+			// Continues the previous message, e.g. "have (int)\n\twant (string)"
+			diagnostics[len(diagnostics)-1].Message += "\n" + line
+			continue
+		}
+		match := diagnosticLine.FindStringSubmatch(line)
+		if match == nil {
+			diagnostics = append(diagnostics, Diagnostic{Package: pkg, Message: line})
+			continue
+		}
+		file := match[1]
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		lineNumber, _ := strconv.Atoi(match[2])
+		column, _ := strconv.Atoi(match[3])
+		diagnostics = append(diagnostics, Diagnostic{
+			Package: pkg,
+			File:    file,
+			Line:    lineNumber,
+			Column:  column,
+			Message: match[4],
+		})
+	}
+	return diagnostics
+}
@@ -0,0 +1,113 @@
+package build
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDiagnostics(t *testing.T) {
+	cases := []struct {
+		description         string
+		output              string
+		expectedDiagnostics []Diagnostic
+	}{
+		{
+			description: "type errors",
+			output: `# example.com/tool
+./main.go:5:2: undefined: foo
+lib/lib.go:10:9: cannot use x (variable of type int) as string value in return statement
+`,
+			expectedDiagnostics: []Diagnostic{
+				{Package: "example.com/tool", File: "/src/main.go", Line: 5, Column: 2, Message: "undefined: foo"},
+				{Package: "example.com/tool", File: "/src/lib/lib.go", Line: 10, Column: 9, Message: "cannot use x (variable of type int) as string value in return statement"},
+			},
+		},
+		{
+			description: "continuation lines",
+			output: `# example.com/tool
+/abs/main.go:7:13: not enough arguments in call to f
+	have ()
+	want (int)
+`,
+			expectedDiagnostics: []Diagnostic{
+				{Package: "example.com/tool", File: "/abs/main.go", Line: 7, Column: 13, Message: "not enough arguments in call to f\n\thave ()\n\twant (int)"},
+			},
+		},
+		{
+			description: "no column",
+			output:      "main.go:3: some error\n",
+			expectedDiagnostics: []Diagnostic{
+				{File: "/src/main.go", Line: 3, Message: "some error"},
+			},
+		},
+		{
+			description: "errors without a location",
+			output:      "go: cannot find main module, but found .git/config in /src\n",
+			expectedDiagnostics: []Diagnostic{
+				{Message: "go: cannot find main module, but found .git/config in /src"},
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			assert.Equal(t, tc.expectedDiagnostics, parseDiagnostics(tc.output, "/src"))
+		})
+	}
+}
+
+func TestCompileErrorDiagnostics(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":  "module example.com/broken\n\ngo 1.22\n",
+		"main.go": "package main\n\nfunc main() {\n\tundefinedFunction()\n}\n",
+	})
+	c := Context{MainPackage: ".", Directory: dir}
+	err := (&DefaultCompiler{}).compile(context.Background(), c, filepath.Join(t.TempDir(), "out"))
+
+	var compileErr *CompileError
+	assert.ErrorAs(t, err, &compileErr)
+	assert.Equal(t, []Diagnostic{{
+		Package: "example.com/broken",
+		File:    filepath.Join(dir, "main.go"),
+		Line:    4,
+		Column:  2,
+		Message: "undefined: undefinedFunction",
+	}}, compileErr.Diagnostics)
+}
+
+func TestLoadError(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":  "module example.com/broken\n\ngo 1.22\n",
+		"main.go": "func main() {}\n",
+	})
+	c := Context{MainPackage: ".", Directory: dir}
+	err := (&DefaultCompiler{}).compile(context.Background(), c, filepath.Join(t.TempDir(), "out"))
+
+	var compileErr *CompileError
+	assert.ErrorAs(t, err, &compileErr)
+	assert.Equal(t, []Diagnostic{{
+		File:    filepath.Join(dir, "main.go"),
+		Line:    1,
+		Column:  1,
+		Message: "expected 'package', found 'func'",
+	}}, compileErr.Diagnostics)
+}
+
+func TestGoCommandError(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":  "module example.com/missing\n\ngo 1.22\n\nrequire example.com/nowhere v1.0.0\n",
+		"main.go": "package main\n\nimport _ \"example.com/nowhere\"\n\nfunc main() {}\n",
+	})
+	c := Context{MainPackage: ".", Directory: dir, Env: []string{"GOPROXY=off", "GOFLAGS=-mod=mod"}}
+	err := (&DefaultCompiler{}).compile(context.Background(), c, filepath.Join(t.TempDir(), "out"))
+
+	var compileErr *CompileError
+	assert.Error(t, err)
+	assert.False(t, errors.As(err, &compileErr), "%v", err)
+}
@@ -0,0 +1,96 @@
+package build
+
+import (
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// buildEnvVars are the environment variables that can change the result of a
+// build. Builds see the client's values for these, never the daemon's.
+var buildEnvVars = []string{
+	"GOOS", "GOARCH", "GO386", "GOAMD64", "GOARM", "GOARM64", "GOMIPS", "GOMIPS64",
+	"GOPPC64", "GORISCV64", "GOWASM",
+	"GOFLAGS", "GOEXPERIMENT", "GO111MODULE", "GOWORK", "GOENV", "GOTOOLCHAIN", "GOROOT",
+	"GOPATH", "GOMODCACHE", "GOPROXY", "GOPRIVATE", "GONOPROXY", "GONOSUMDB", "GOSUMDB", "GOINSECURE",
+	"CGO_ENABLED", "CGO_CFLAGS", "CGO_CPPFLAGS", "CGO_CXXFLAGS", "CGO_FFLAGS", "CGO_LDFLAGS",
+	"CC", "CXX", "FC", "AR", "PKG_CONFIG",
+}
+
+// fetchEnvVars are the build environment variables that only decide where
+// modules come from and where they are kept, not what is built from them. They
+// differ from machine to machine, so they are left out of the input hash.
+var fetchEnvVars = []string{
+	"GOENV", "GOTOOLCHAIN", "GOROOT", "GOPATH", "GOMODCACHE", "GOPROXY", "GOPRIVATE",
+	"GONOPROXY", "GONOSUMDB", "GOSUMDB", "GOINSECURE",
+}
+
+// outputEnv is the part of the context's environment that can change the
+// binary built
+func (e Context) outputEnv() []string {
+	var result []string
+	for _, kv := range e.Env {
+		name, _, _ := strings.Cut(kv, "=")
+		if !slices.Contains(fetchEnvVars, name) {
+			result = append(result, kv)
+		}
+	}
+	return result
+}
+
+// BuildEnv returns the build-relevant variables from env, in a stable order
+func BuildEnv(env []string) []string {
+	values := make(map[string]string)
+	for _, kv := range env {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok || !slices.Contains(buildEnvVars, name) {
+			continue
+		}
+		// Like os/exec, later values take precedence
+		values[name] = value
+	}
+	var result []string
+	for _, name := range buildEnvVars {
+		if value, ok := values[name]; ok {
+			result = append(result, name+"="+value)
+		}
+	}
+	return result
+}
+
+// environ is the environment to run the go command in for this context:
+// the daemon's own environment, with the build-relevant variables replaced
+// by the context's
+func (e Context) environ() []string {
+	var result []string
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if slices.Contains(buildEnvVars, name) {
+			continue
+		}
+		result = append(result, kv)
+	}
+	result = append(result, e.Env...)
+	if e.Toolchain != "" {
+		// An explicitly chosen toolchain should not switch to another
+		result = append(result, "GOTOOLCHAIN=local")
+	}
+	return result
+}
+
+// platform is the GOOS and GOARCH that builds for this context target, which
+// are the daemon's own unless the client's environment sets them
+func (e Context) platform() (goos, goarch string) {
+	goos, goarch = runtime.GOOS, runtime.GOARCH
+	for _, kv := range e.Env {
+		name, value, _ := strings.Cut(kv, "=")
+		switch {
+		case name == "GOOS" && value != "":
+			goos = value
+		case name == "GOARCH" && value != "":
+			goarch = value
+		}
+	}
+	return goos, goarch
+}
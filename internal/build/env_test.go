@@ -0,0 +1,71 @@
+package build
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildEnv(t *testing.T) {
+	cases := []struct {
+		description string
+		env         []string
+		expectedEnv []string
+	}{
+		{
+			description: "unrelated variables are dropped",
+			env:         []string{"HOME=/home/user", "PWD=/src", "TERM=xterm"},
+			expectedEnv: nil,
+		},
+		{
+			description: "build variables are kept in a stable order",
+			env:         []string{"CGO_ENABLED=0", "HOME=/home/user", "GOARCH=arm64", "GOOS=darwin"},
+			expectedEnv: []string{"GOOS=darwin", "GOARCH=arm64", "CGO_ENABLED=0"},
+		},
+		{
+			description: "later values take precedence",
+			env:         []string{"GOFLAGS=-mod=mod", "GOFLAGS=-mod=vendor"},
+			expectedEnv: []string{"GOFLAGS=-mod=vendor"},
+		},
+		{
+			description: "empty values are kept",
+			env:         []string{"GOFLAGS="},
+			expectedEnv: []string{"GOFLAGS="},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			assert.Equal(t, tc.expectedEnv, BuildEnv(tc.env))
+		})
+	}
+}
+
+func TestEnvironUsesContextValues(t *testing.T) {
+	t.Setenv("GOOS", "plan9")
+	t.Setenv("GOARCH", "386")
+	t.Setenv("GORUN_UNRELATED", "kept")
+
+	c := Context{Env: []string{"GOOS=linux"}}
+	env := c.environ()
+
+	assert.Contains(t, env, "GOOS=linux")
+	assert.Contains(t, env, "GORUN_UNRELATED=kept")
+	assert.NotContains(t, env, "GOOS=plan9")
+	assert.NotContains(t, env, "GOARCH=386")
+}
+
+func TestEnvChangesKey(t *testing.T) {
+	linux := Context{MainPackage: ".", Env: []string{"GOOS=linux"}}
+	darwin := Context{MainPackage: ".", Env: []string{"GOOS=darwin"}}
+	assert.NotEqual(t, linux.Key(), darwin.Key())
+}
+
+func TestInputHashIgnoresFetchEnv(t *testing.T) {
+	mine := Context{MainPackage: ".", Env: []string{"GOOS=linux", "GOPATH=/home/me/go", "GOPROXY=direct"}}
+	theirs := Context{MainPackage: ".", Env: []string{"GOOS=linux", "GOPATH=/home/ci/go", "GOPROXY=https://proxy.example.com"}}
+	assert.Equal(t, mine.inputHash("sources"), theirs.inputHash("sources"))
+	assert.NotEqual(t, mine.Key(), theirs.Key())
+
+	darwin := Context{MainPackage: ".", Env: []string{"GOOS=darwin", "GOPATH=/home/me/go", "GOPROXY=direct"}}
+	assert.NotEqual(t, mine.inputHash("sources"), darwin.inputHash("sources"))
+}
@@ -0,0 +1,106 @@
+package build
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	log "github.com/lukemassa/clilog"
+)
+
+// ErrBuilding is returned when removing a context that is being built
+var ErrBuilding = errors.New("a build is in progress")
+
+// forget drops e from the cache, leaving its binaries on disk. Must be called
+// with e's buildBarrier held.
+func (s *Cache) forget(key string, e *executable) {
+	log.Infof("Evicting %+v from the cache", e.context)
+	e.currentPath = ""
+	e.fingerprint = ""
+	e.dirs = nil
+	e.failed = false
+	e.failure = nil
+	e.failedFingerprint = ""
+	s.unwatch(e.context)
+	s.manifest.remove(key)
+}
+
+// Evict removes the context and its binaries from the cache, returning whether
+// it was there. Its binaries stay in the object store, for other contexts
+// built from the same inputs, until garbage collection removes them.
+func (s *Cache) Evict(executableContext Context) (bool, error) {
+	key := executableContext.Key()
+	s.mu.Lock()
+	e, ok := s.executables[key]
+	s.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+
+	e.buildBarrier.Lock()
+	defer e.buildBarrier.Unlock()
+	if e.building != nil {
+		return false, ErrBuilding
+	}
+	found := e.currentPath != ""
+	s.forget(key, e)
+	err := os.RemoveAll(filepath.Join(s.cacheDir, key))
+	return found, err
+}
+
+// Clean removes every binary from the cache, including the object store,
+// except for those of contexts being built
+func (s *Cache) Clean() (GCResult, error) {
+	entries, err := s.binaries()
+	if err != nil {
+		return GCResult{}, err
+	}
+	byKey := make(map[string][]LRUFile)
+	for _, entry := range entries {
+		byKey[entry.Key] = append(byKey[entry.Key], entry)
+	}
+
+	var result GCResult
+	removeAll := func(entries []LRUFile) {
+		for _, entry := range entries {
+			if entry.Shared {
+				info, err := os.Stat(entry.Path)
+				if err != nil || links(info) > 1 {
+					// Still linked from a context being built
+					continue
+				}
+			}
+			err := os.Remove(entry.Path)
+			if err != nil {
+				log.Warnf("Failed to remove %s: %v", entry.Path, err)
+				continue
+			}
+			result.Removed++
+			result.Freed += entry.Size
+		}
+	}
+	for key, keyEntries := range byKey {
+		if key == "" {
+			continue
+		}
+		s.mu.Lock()
+		e, ok := s.executables[key]
+		s.mu.Unlock()
+		if !ok {
+			removeAll(keyEntries)
+			continue
+		}
+		e.buildBarrier.Lock()
+		if e.building == nil {
+			s.forget(key, e)
+			removeAll(keyEntries)
+			_ = os.Remove(filepath.Join(s.cacheDir, key))
+		}
+		e.buildBarrier.Unlock()
+	}
+	// Objects last, once the contexts no longer link to them
+	removeAll(byKey[""])
+	s.removeEmptyKeyDirs()
+	log.Infof("Cleaning removed %d binaries, freeing %d bytes", result.Removed, result.Freed)
+	return result, nil
+}
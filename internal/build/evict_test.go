@@ -0,0 +1,82 @@
+package build
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvict(t *testing.T) {
+	dir := t.TempDir()
+	compiler := newMockCompiler()
+	cache := NewCache(dir, compiler)
+	c := Context{MainPackage: "example"}
+
+	found, err := cache.Evict(c)
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	path, err := cache.GetExecutableFromContext(context.Background(), c)
+	assert.NoError(t, err)
+	found, err = cache.Evict(c)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.NoFileExists(t, path)
+	assert.Empty(t, cache.List(context.Background()))
+	assert.Empty(t, cache.manifest.entries())
+
+	// The same inputs are still in the object store
+	assert.FileExists(t, filepath.Join(dir, objectsDir, filepath.Base(path)))
+	_, err = cache.GetExecutableFromContext(context.Background(), c)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, compiler.compiles)
+}
+
+func TestEvictWhileBuilding(t *testing.T) {
+	compiler := newHangingCompiler()
+	cache := NewCache(t.TempDir(), compiler)
+	c := Context{MainPackage: "example"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_, _ = cache.GetExecutableFromContext(ctx, c)
+	}()
+	<-compiler.started
+
+	_, err := cache.Evict(c)
+	assert.ErrorIs(t, err, ErrBuilding)
+	assert.Equal(t, []Entry{{Context: c, Building: true}}, cache.List(context.Background()))
+	total, building := cache.Count()
+	assert.Equal(t, 1, total)
+	assert.Equal(t, 1, building)
+}
+
+func TestClean(t *testing.T) {
+	dir := t.TempDir()
+	compiler := newMockCompiler()
+	cache := NewCache(dir, compiler)
+
+	first, err := cache.GetExecutableFromContext(context.Background(), Context{Directory: "/one", Flags: Flags{TrimPath: true}})
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(first, []byte("0123456789"), 0o700))
+	second, err := cache.GetExecutableFromContext(context.Background(), Context{Directory: "/two", Flags: Flags{TrimPath: true}})
+	assert.NoError(t, err)
+	leftover := writeBinary(t, dir, keyA, "a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1", 5, 0)
+
+	result, err := cache.Clean()
+	assert.NoError(t, err)
+	assert.Equal(t, GCResult{Removed: 4, Freed: 15}, result)
+	assert.NoFileExists(t, first)
+	assert.NoFileExists(t, second)
+	assert.NoFileExists(t, leftover)
+	assert.NoFileExists(t, filepath.Join(dir, objectsDir, filepath.Base(first)))
+	assert.Empty(t, cache.List(context.Background()))
+
+	_, err = cache.GetExecutableFromContext(context.Background(), Context{Directory: "/one", Flags: Flags{TrimPath: true}})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, compiler.compiles)
+}
@@ -1,13 +1,19 @@
 package build
 
 import (
+	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/zeebo/xxh3"
 
@@ -17,49 +23,184 @@ import (
 type Context struct {
 	MainPackage string
 	Directory   string
+	Flags       Flags
+	// Env holds the build-relevant environment variables, see BuildEnv
+	Env []string
+	// Toolchain is the go command to build with, or empty for the go on the daemon's PATH
+	Toolchain string
+	// GoVersion is the version Toolchain reports for this context, see Resolve
+	GoVersion string
 }
 
 type Cache struct {
-	cacheDir    string
-	compiler    compiler
-	mu          sync.Mutex
-	executables map[string]*executable
+	cacheDir     string
+	compiler     compiler
+	watcher      Watcher
+	backend      Backend
+	buildTimeout time.Duration
+	scheduler    *scheduler
+	mu           sync.Mutex
+	executables  map[string]*executable
+	manifest     manifest
+}
+
+// ErrBuildTimeout is returned to everyone waiting on a build that took too long
+var ErrBuildTimeout = errors.New("build timed out")
+
+// Watcher is told about the directories behind each cached context, so that
+// it can trigger rebuilds when they change. Watch returns an error if any of
+// the directories can't be watched, in which case the Watcher can't be relied
+// on to report every change, see Cache.Changed.
+type Watcher interface {
+	Watch(c Context, dirs []string) error
+	Unwatch(c Context)
 }
 
 type executable struct {
-	currentPath  string
+	context     Context
+	currentPath string
+	// fingerprint of the sources currentPath was built from
+	fingerprint string
+	// dirs of the sources currentPath was built from
+	dirs []string
+	// watched is true if the watcher is following every one of dirs, so
+	// that changed can be trusted
+	watched bool
+	// changed is set as soon as the watcher sees files in dirs change, and
+	// cleared just before the sources are fingerprinted again
+	changed atomic.Bool
+	builtAt time.Time
+	// buildDuration is how long currentPath took to build, zero if it was reused
+	buildDuration time.Duration
+	hits          int
+	// failed is true if the latest build failed, in which case currentPath is
+	// kept around as a fallback
+	failed bool
+	// failure is the compile error from building failedFingerprint, returned
+	// straight away until the sources change, see Options.Retry
+	failure           error
+	failedFingerprint string
+	// building is the build in progress, if any
+	building *pendingBuild
+	// buildBarrier guards all of the above
 	buildBarrier sync.Mutex
 }
 
+// pendingBuild is a build in progress, shared by everyone waiting on it
+type pendingBuild struct {
+	done chan struct{}
+	path string
+	err  error
+	// waiters is the number of callers still interested in the result. When
+	// it drops to zero the build is cancelled. Guarded by the buildBarrier.
+	waiters int
+	cancel  context.CancelFunc
+	// ticket is the build's place in the scheduler
+	ticket *ticket
+	// events is the progress so far. Guarded by the buildBarrier.
+	events []Event
+	// updated is closed, and replaced, whenever events changes
+	updated chan struct{}
+}
+
+// addEvent records progress. Must be called with e's buildBarrier held.
+func (b *pendingBuild) addEvent(event Event) {
+	b.events = append(b.events, event)
+	close(b.updated)
+	b.updated = make(chan struct{})
+}
+
 func NewCache(cacheDir string, compiler compiler) *Cache {
 	return &Cache{
 		cacheDir:    cacheDir,
 		compiler:    compiler,
 		executables: make(map[string]*executable),
+		scheduler:   newScheduler(),
+		manifest:    newManifest(filepath.Join(cacheDir, manifestFile)),
+	}
+}
+
+// SetBuildTimeout bounds how long a build may take, zero meaning no limit
+func (s *Cache) SetBuildTimeout(timeout time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buildTimeout = timeout
+}
+
+// SetMaxParallelBuilds limits how many builds run at once, zero meaning no limit
+func (s *Cache) SetMaxParallelBuilds(max int) {
+	s.scheduler.setMax(max)
+}
+
+// SetWatcher registers a Watcher to be kept up to date as contexts are built,
+// starting with the contexts already in the cache. Setting it to nil, say if
+// it stops working, goes back to fingerprinting sources on every use.
+func (s *Cache) SetWatcher(w Watcher) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.watcher = w
+	if w == nil {
+		return
+	}
+	for _, entry := range s.manifest.entries() {
+		err := w.Watch(entry.Context, entry.Dirs)
+		if err != nil {
+			log.Warnf("Failed to watch the sources of %v: %v", entry.Context, err)
+		}
 	}
 }
 
 type compiler interface {
-	compile(e Context, outputFile string) error
+	compile(ctx context.Context, e Context, outputFile string) error
+	sources(ctx context.Context, e Context) (sources, error)
+	resolve(ctx context.Context, e Context) (Context, error)
 }
 
 type DefaultCompiler struct{}
 
-func (d *DefaultCompiler) compile(executableContext Context, outputFile string) error {
-	cmd := exec.Command("go", "build", "-o", outputFile, executableContext.MainPackage)
-	cmd.Dir = executableContext.Directory
-	log.Infof("Running go build -o %s %s at %s", outputFile, executableContext.MainPackage, executableContext.Directory)
-	output, err := cmd.CombinedOutput()
+func (d *DefaultCompiler) compile(ctx context.Context, executableContext Context, outputFile string) error {
+	if executableContext.remote() {
+		return d.compileRemote(ctx, executableContext, outputFile)
+	}
+	args := []string{"build"}
+	args = append(args, executableContext.Flags.Args()...)
+	args = append(args, "-o", outputFile, executableContext.MainPackage)
+	return executableContext.goBuild(ctx, executableContext.Directory, executableContext.environ(), args)
+}
+
+func (e Context) goBuild(ctx context.Context, dir string, env []string, args []string) error {
+	cmd := e.goCmd(ctx, dir, env, args...)
+	log.Infof("Running %s %s at %s", e.goBinary(), strings.Join(args, " "), dir)
+	var buf bytes.Buffer
+	lines := outputLines(ctx)
+	cmd.Stdout = io.MultiWriter(&buf, lines)
+	cmd.Stderr = cmd.Stdout
+	err := cmd.Run()
+	lines.Flush()
+	output := buf.Bytes()
 	if err != nil {
 		log.Warnf("Failed to build: %s", string(output))
-		// do better
-		return fmt.Errorf("%s", string(output))
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if len(output) == 0 {
+			return err
+		}
+		diagnostics := parseDiagnostics(string(output), dir)
+		if !fromSources(diagnostics, dir) {
+			return fmt.Errorf("go %s: %s", args[0], strings.TrimSpace(string(output)))
+		}
+		return &CompileError{
+			Output:      string(output),
+			Diagnostics: diagnostics,
+		}
 	}
 	return nil
 }
 
 func (e Context) Key() string {
-	b := fmt.Appendf(nil, "%s\x00%s", e.MainPackage, e.Directory)
+	b := fmt.Appendf(nil, "%s\x00%s\x00%s\x00%s\x00%s\x00%s", e.MainPackage, e.Directory,
+		strings.Join(e.Flags.Args(), "\x00"), strings.Join(e.Env, "\x00"), e.Toolchain, e.GoVersion)
 	return hashBytes(b)
 }
 
@@ -69,32 +210,368 @@ func hashBytes(in []byte) string {
 	return hex.EncodeToString(b[:])
 }
 
-func (s *Cache) GetExecutableFromContext(executableContext Context) (string, error) {
-
+// executable returns the cache entry for a context, creating it if needed
+func (s *Cache) executable(executableContext Context) (string, *executable) {
 	key := executableContext.Key()
-	var e *executable
 	s.mu.Lock()
+	defer s.mu.Unlock()
 	e, ok := s.executables[key]
 	if !ok {
-		e = &executable{}
+		e = &executable{context: executableContext}
 		s.executables[key] = e
 	}
-	s.mu.Unlock()
+	return key, e
+}
+
+// Options change how Get finds an executable
+type Options struct {
+	// AllowStale returns the previous build straight away if the context needs
+	// rebuilding, leaving the rebuild to carry on in the background
+	AllowStale bool
+	// Fallback returns the last good build, if there is one, along with the
+	// error when a build fails
+	Fallback bool
+	// Retry builds even if the sources are unchanged since the last build
+	// failed, rather than returning the same compile error again
+	Retry bool
+}
+
+// Result is an executable returned by Get
+type Result struct {
+	Path string
+	// Stale is true if Path was built from older sources than the current ones
+	Stale bool
+	// Fallback is true if Path is the last good build, because the build failed
+	Fallback bool
+	// BuiltAt is when Path was built
+	BuiltAt time.Time
+}
+
+// GetExecutableFromContext returns the path of an up to date executable for
+// the context, building it if needed
+func (s *Cache) GetExecutableFromContext(ctx context.Context, executableContext Context) (string, error) {
+	result, err := s.Get(ctx, executableContext, Options{})
+	return result.Path, err
+}
+
+// Get returns an executable for the context, building it if needed with
+// Interactive priority. If ctx is cancelled while waiting, the build carries
+// on for any other waiters, or is cancelled if there are none. Progress is
+// reported to any Reporter in ctx.
+func (s *Cache) Get(ctx context.Context, executableContext Context, opts Options) (Result, error) {
+	key, e := s.executable(executableContext)
 
 	e.buildBarrier.Lock()
-	if e.currentPath != "" {
+	b := e.building
+	if b != nil && opts.AllowStale && e.currentPath != "" {
+		log.Infof("Serving stale %s while the build in progress finishes", e.currentPath)
+		result := s.stale(key, e)
+		e.buildBarrier.Unlock()
+		return result, nil
+	}
+	if b == nil && s.unchanged(e) {
+		log.Infof("Path found %s in cache, and the watcher has seen no change to its sources", e.currentPath)
+		result := s.hit(key, e)
 		e.buildBarrier.Unlock()
-		log.Infof("Path found %s in cache", e.currentPath)
-		return e.currentPath, nil
+		return result, nil
+	}
+	if b == nil {
+		// Changes seen from here on may be missing from the fingerprint
+		e.changed.Store(false)
+		sourcesCtx, cancel := s.withBuildTimeout(ctx)
+		src := s.sources(sourcesCtx, executableContext)
+		cancel()
+		if e.currentPath != "" && src.Fingerprint != "" && src.Fingerprint == e.fingerprint {
+			log.Infof("Path found %s in cache", e.currentPath)
+			if !e.watched {
+				// Say it was built before the daemon restarted, so the
+				// watcher may not have been following it all along
+				e.watched = s.watch(executableContext, src)
+			}
+			result := s.hit(key, e)
+			e.buildBarrier.Unlock()
+			return result, nil
+		}
+		if path, ok := s.reuse(executableContext, src); ok {
+			log.Infof("Reusing %s, built from identical inputs", path)
+			s.built(key, e, path, src, 0)
+			result := Result{Path: path, BuiltAt: e.builtAt}
+			e.buildBarrier.Unlock()
+			return result, nil
+		}
+		if e.failure != nil && src.Fingerprint != "" && src.Fingerprint == e.failedFingerprint && !opts.Retry {
+			log.Infof("Sources unchanged since the build of %v failed, not retrying", executableContext)
+			err := e.failure
+			result := s.fallback(e, opts)
+			e.buildBarrier.Unlock()
+			return result, err
+		}
+		if e.currentPath != "" && opts.AllowStale {
+			log.Infof("Sources changed since %s was built, serving it while rebuilding", e.currentPath)
+			s.startBuild(key, e, src, Background, true)
+			result := s.stale(key, e)
+			e.buildBarrier.Unlock()
+			return result, nil
+		}
+		if e.currentPath != "" {
+			log.Infof("Sources changed since %s was built", e.currentPath)
+		}
+		log.Infof("Must compile for %v", executableContext)
+		b = s.startBuild(key, e, src, Interactive, true)
+	} else {
+		log.Infof("Waiting on build in progress for %v", executableContext)
+		b.ticket.raise(Interactive)
+	}
+	b.waiters++
+	e.buildBarrier.Unlock()
+
+	path, err := s.wait(ctx, e, b)
+	if err != nil {
+		if ctx.Err() != nil {
+			return Result{}, err
+		}
+		e.buildBarrier.Lock()
+		defer e.buildBarrier.Unlock()
+		return s.fallback(e, opts), err
 	}
-	log.Infof("Must compile for %v", executableContext)
+	e.buildBarrier.Lock()
 	defer e.buildBarrier.Unlock()
-	newPath, err := s.compile(executableContext)
+	return Result{Path: path, BuiltAt: e.builtAt}, nil
+}
+
+// fallback returns the last good build of e, if there is one and opts asks
+// for it, after a build failed. Must be called with e's buildBarrier held.
+func (s *Cache) fallback(e *executable, opts Options) Result {
+	if !opts.Fallback || e.currentPath == "" {
+		return Result{}
+	}
+	log.Infof("Falling back to %s, the last good build", e.currentPath)
+	touch(e.currentPath)
+	return Result{Path: e.currentPath, Fallback: true, BuiltAt: e.builtAt}
+}
+
+// unchanged is true if e has an up to date build, going by the watcher
+// having seen no change to its sources since they were last fingerprinted.
+// Must be called with e's buildBarrier held.
+func (s *Cache) unchanged(e *executable) bool {
+	s.mu.Lock()
+	w := s.watcher
+	s.mu.Unlock()
+	return w != nil && e.watched && e.currentPath != "" && !e.failed && !e.changed.Load()
+}
+
+// hit returns the current build of e, which is up to date. Must be called
+// with e's buildBarrier held.
+func (s *Cache) hit(key string, e *executable) Result {
+	touch(e.currentPath)
+	e.hits++
+	s.recordHit(key, e)
+	return Result{Path: e.currentPath, BuiltAt: e.builtAt}
+}
+
+// stale returns the current build of e, which is out of date. Must be called
+// with e's buildBarrier held.
+func (s *Cache) stale(key string, e *executable) Result {
+	touch(e.currentPath)
+	e.hits++
+	s.recordHit(key, e)
+	return Result{Path: e.currentPath, Stale: true, BuiltAt: e.builtAt}
+}
+
+// withBuildTimeout applies the cache's build timeout, if any, to ctx
+func (s *Cache) withBuildTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	s.mu.Lock()
+	timeout := s.buildTimeout
+	s.mu.Unlock()
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeoutCause(ctx, timeout, fmt.Errorf("%w after %s", ErrBuildTimeout, timeout))
+}
+
+// startBuild compiles e in the background once the scheduler allows it. If
+// reuse is set, a binary from the backend may be used instead. Must be called
+// with e's buildBarrier held.
+func (s *Cache) startBuild(key string, e *executable, src sources, priority Priority, reuse bool) *pendingBuild {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &pendingBuild{
+		done:    make(chan struct{}),
+		cancel:  cancel,
+		ticket:  s.scheduler.enqueue(priority),
+		updated: make(chan struct{}),
+	}
+	e.building = b
+
+	go func() {
+		defer cancel()
+		path, duration, err := s.scheduledCompile(ctx, e, b, src.Fingerprint, reuse)
+		if err != nil && context.Cause(ctx) != nil {
+			// Report why the build was stopped, rather than how it failed as a result
+			err = context.Cause(ctx)
+		}
+		if err != nil {
+			log.Warnf("Build of %v failed: %v", e.context, err)
+		}
+
+		e.buildBarrier.Lock()
+		defer e.buildBarrier.Unlock()
+		if err == nil {
+			s.built(key, e, path, src, duration)
+		} else {
+			var compileErr *CompileError
+			if errors.As(err, &compileErr) && src.Fingerprint != "" {
+				e.failure = err
+				e.failedFingerprint = src.Fingerprint
+			}
+			if !errors.Is(err, context.Canceled) && e.currentPath != "" {
+				e.failed = true
+				s.record(key, e)
+			}
+		}
+		e.building = nil
+		b.path = path
+		b.err = err
+		close(b.done)
+	}()
+	return b
+}
+
+// scheduledCompile waits for a build slot, then compiles within the build
+// timeout, returning how long the compile took
+func (s *Cache) scheduledCompile(ctx context.Context, e *executable, b *pendingBuild, fingerprint string, reuse bool) (string, time.Duration, error) {
+	err := b.ticket.wait(ctx, func(position int) {
+		e.buildBarrier.Lock()
+		defer e.buildBarrier.Unlock()
+		b.addEvent(Event{Kind: EventQueued, QueuePosition: position})
+	})
 	if err != nil {
-		return "", err
+		return "", 0, err
+	}
+	defer b.ticket.release()
+	started := time.Now()
+
+	e.buildBarrier.Lock()
+	b.addEvent(Event{Kind: EventBuilding})
+	e.buildBarrier.Unlock()
+
+	ctx, cancel := s.withBuildTimeout(ctx)
+	defer cancel()
+	ctx = withOutput(ctx, func(line string) {
+		e.buildBarrier.Lock()
+		defer e.buildBarrier.Unlock()
+		b.addEvent(Event{Kind: EventOutput, Line: line})
+	})
+	path, err := s.compile(ctx, e.context, fingerprint, reuse)
+	if err != nil && context.Cause(ctx) != nil {
+		return "", 0, context.Cause(ctx)
 	}
+	return path, time.Since(started), err
+}
+
+// wait returns the result of the build, unless ctx is done first. Meanwhile
+// it passes on the build's progress to any Reporter in ctx.
+func (s *Cache) wait(ctx context.Context, e *executable, b *pendingBuild) (string, error) {
+	reported := 0
+	for {
+		e.buildBarrier.Lock()
+		events := b.events[reported:]
+		updated := b.updated
+		e.buildBarrier.Unlock()
+		for _, event := range events {
+			report(ctx, event)
+		}
+		reported += len(events)
+
+		select {
+		case <-b.done:
+			return b.path, b.err
+		case <-updated:
+		case <-ctx.Done():
+			e.buildBarrier.Lock()
+			defer e.buildBarrier.Unlock()
+			b.waiters--
+			if b.waiters == 0 {
+				log.Infof("Nobody is waiting on the build of %v, cancelling it", e.context)
+				b.cancel()
+			}
+			return "", ctx.Err()
+		}
+	}
+}
+
+// built updates e after a successful build, which took duration. Must be
+// called with e's buildBarrier held.
+func (s *Cache) built(key string, e *executable, newPath string, src sources, duration time.Duration) {
 	e.currentPath = newPath
-	return newPath, nil
+	e.fingerprint = src.Fingerprint
+	e.dirs = src.Dirs
+	e.builtAt = time.Now()
+	e.buildDuration = duration
+	e.failed = false
+	e.failure = nil
+	e.failedFingerprint = ""
+	e.watched = s.watch(e.context, src)
+	s.record(key, e)
+}
+
+// touch marks the binary at path as just used, which protects it from
+// garbage collection for a while, see Collect
+func touch(path string) {
+	now := time.Now()
+	err := os.Chtimes(path, now, now)
+	if err != nil {
+		log.Warnf("Failed to mark %s as used: %v", path, err)
+	}
+}
+
+// watch tells the watcher, if any, about the directories behind a fresh
+// build, returning whether it is following all of them
+func (s *Cache) watch(executableContext Context, src sources) bool {
+	s.mu.Lock()
+	w := s.watcher
+	s.mu.Unlock()
+	if w == nil || src.Fingerprint == "" {
+		return false
+	}
+	err := w.Watch(executableContext, src.Dirs)
+	if err != nil {
+		log.Warnf("Failed to watch the sources of %v, will fingerprint them on every use: %v", executableContext, err)
+		return false
+	}
+	return true
+}
+
+// Changed tells the cache that files behind a context have changed, so that
+// its sources are fingerprinted again before its build is next used
+func (s *Cache) Changed(executableContext Context) {
+	s.mu.Lock()
+	e, ok := s.executables[executableContext.Key()]
+	s.mu.Unlock()
+	if ok {
+		e.changed.Store(true)
+	}
+}
+
+// unwatch tells the watcher, if any, that a context has left the cache
+func (s *Cache) unwatch(executableContext Context) {
+	s.mu.Lock()
+	w := s.watcher
+	s.mu.Unlock()
+	if w == nil {
+		return
+	}
+	w.Unwatch(executableContext)
+}
+
+// sources fingerprints the inputs of a build. If that fails, the returned
+// fingerprint is empty, which never matches, so the executable is rebuilt.
+func (s *Cache) sources(ctx context.Context, executableContext Context) sources {
+	src, err := s.compiler.sources(ctx, executableContext)
+	if err != nil {
+		log.Warnf("Failed to fingerprint %v, will rebuild: %v", executableContext, err)
+		return sources{}
+	}
+	return src
 }
 
 func randomHex32() (string, error) {
@@ -105,7 +582,11 @@ func randomHex32() (string, error) {
 	return hex.EncodeToString(b), nil
 }
 
-func (s *Cache) compile(executableContext Context) (string, error) {
+// compile builds the context into its directory in the cache. The binary is
+// named by its input hash and stored for reuse, unless the fingerprint is not
+// known, in which case it gets a random name. If reuse is set, the binary is
+// downloaded from the backend instead of compiled, if it is there.
+func (s *Cache) compile(ctx context.Context, executableContext Context, fingerprint string, reuse bool) (string, error) {
 	key := executableContext.Key()
 
 	outputDir := filepath.Join(s.cacheDir, key)
@@ -120,16 +601,51 @@ func (s *Cache) compile(executableContext Context) (string, error) {
 	}
 
 	newPath := filepath.Join(outputDir, filename)
-	err = s.compiler.compile(executableContext, newPath)
+	if fingerprint == "" {
+		err = s.compiler.compile(ctx, executableContext, newPath)
+		if err != nil {
+			return "", err
+		}
+		return newPath, nil
+	}
+
+	inputs := executableContext.inputHash(fingerprint)
+	downloaded := reuse && s.download(ctx, inputs, newPath)
+	if !downloaded {
+		err = s.compiler.compile(ctx, executableContext, newPath)
+		if err != nil {
+			return "", err
+		}
+	}
+	finalPath := filepath.Join(outputDir, inputs)
+	err = os.Rename(newPath, finalPath)
 	if err != nil {
 		return "", err
 	}
-	return newPath, nil
+	s.store(inputs, finalPath)
+	if !downloaded {
+		s.upload(executableContext, inputs, finalPath)
+	}
+	return finalPath, nil
+}
+
+// Recompile builds the context again, even if it is up to date. If a build is
+// already in progress, it waits for that one instead.
+func (s *Cache) Recompile(ctx context.Context, executableContext Context, priority Priority) error {
+	log.Infof("Re-compiling compilation for %+v (%s)", executableContext, executableContext.Key())
+	return s.rebuild(ctx, executableContext, priority, true)
 }
 
-func (s *Cache) Recompile(executableContext Context) error {
+// Refresh builds the context again if its sources have changed since it was
+// last built, or last failed to build. If a build is already in progress, it
+// waits for that one instead.
+func (s *Cache) Refresh(ctx context.Context, executableContext Context, priority Priority) error {
+	return s.rebuild(ctx, executableContext, priority, false)
+}
+
+// rebuild implements Recompile, when force is set, and Refresh
+func (s *Cache) rebuild(ctx context.Context, executableContext Context, priority Priority, force bool) error {
 	key := executableContext.Key()
-	log.Infof("Re-compiling compilation for %+v (%s)", executableContext, key)
 	s.mu.Lock()
 	e, ok := s.executables[key]
 	s.mu.Unlock()
@@ -138,11 +654,37 @@ func (s *Cache) Recompile(executableContext Context) error {
 	}
 
 	e.buildBarrier.Lock()
-	defer e.buildBarrier.Unlock()
-	newPath, err := s.compile(executableContext)
-	if err != nil {
-		return err
+	b := e.building
+	if b == nil {
+		// Changes seen from here on may be missing from the fingerprint
+		e.changed.Store(false)
+		sourcesCtx, cancel := s.withBuildTimeout(ctx)
+		src := s.sources(sourcesCtx, executableContext)
+		cancel()
+		unchanged := src.Fingerprint != "" && (src.Fingerprint == e.fingerprint || src.Fingerprint == e.failedFingerprint)
+		if unchanged && !force {
+			log.Infof("Sources of %v are unchanged, not rebuilding", executableContext)
+			e.buildBarrier.Unlock()
+			return nil
+		}
+		log.Infof("Sources changed for %v, rebuilding", executableContext)
+		// Only a forced rebuild of unchanged sources needs to compile from scratch
+		reuse := src.Fingerprint != e.fingerprint
+		if reuse {
+			if path, ok := s.reuse(executableContext, src); ok {
+				log.Infof("Reusing %s, built from identical inputs", path)
+				s.built(key, e, path, src, 0)
+				e.buildBarrier.Unlock()
+				return nil
+			}
+		}
+		b = s.startBuild(key, e, src, priority, reuse)
+	} else {
+		b.ticket.raise(priority)
 	}
-	e.currentPath = newPath
+	b.waiters++
+	e.buildBarrier.Unlock()
+
+	_, err := s.wait(ctx, e, b)
 	return err
 }
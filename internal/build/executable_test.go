@@ -1,6 +1,8 @@
 package build
 
 import (
+	"context"
+	"errors"
 	"log"
 	"os"
 	"path/filepath"
@@ -12,24 +14,60 @@ import (
 )
 
 type mockCompiler struct {
-	mu         sync.Mutex
-	inProgress map[string]int
+	mu          sync.Mutex
+	inProgress  map[string]int
+	fingerprint string
+	compiles    int
+	// fingerprints counts the calls to sources
+	fingerprints int
+	// err, if set, fails every compile
+	err error
 }
 
 func newMockCompiler() *mockCompiler {
 	return &mockCompiler{
-		inProgress: make(map[string]int),
-		mu:         sync.Mutex{},
+		inProgress:  make(map[string]int),
+		mu:          sync.Mutex{},
+		fingerprint: "initial",
 	}
 }
 
-func (m *mockCompiler) compile(c Context, outputPath string) error {
+func (m *mockCompiler) sources(ctx context.Context, c Context) (sources, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fingerprints++
+	return sources{Fingerprint: m.fingerprint, Dirs: []string{m.fingerprint}}, nil
+}
+
+func (m *mockCompiler) resolve(ctx context.Context, c Context) (Context, error) {
+	return c, nil
+}
+
+func (m *mockCompiler) setFingerprint(fingerprint string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fingerprint = fingerprint
+}
+
+func (m *mockCompiler) setError(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.err = err
+}
+
+func (m *mockCompiler) compile(ctx context.Context, c Context, outputPath string) error {
 	log.Print("Doing a mock compile!")
 	key := c.Key()
 
 	// Simulates compilation, and panics if two compiles are called simultaneously
 	m.mu.Lock()
 	m.inProgress[key]++
+	m.compiles++
+	if m.err != nil {
+		m.inProgress[key]--
+		m.mu.Unlock()
+		return m.err
+	}
 	if m.inProgress[key] > 1 {
 		m.mu.Unlock()
 		panic("concurrent compile")
@@ -55,12 +93,133 @@ func TestExecutableFromContext(t *testing.T) {
 
 	c := Context{}
 	key := c.Key()
-	executable, err := cache.GetExecutableFromContext(c)
+	executable, err := cache.GetExecutableFromContext(context.Background(), c)
 	assert.NoError(t, err)
 	assert.Equal(t, filepath.Join(dir, key), filepath.Dir(executable))
 	assert.FileExists(t, executable)
 }
 
+func TestRecompileWhenSourcesChange(t *testing.T) {
+	dir := t.TempDir()
+	compiler := newMockCompiler()
+	cache := NewCache(dir, compiler)
+
+	c := Context{}
+	first, err := cache.GetExecutableFromContext(context.Background(), c)
+	assert.NoError(t, err)
+
+	second, err := cache.GetExecutableFromContext(context.Background(), c)
+	assert.NoError(t, err)
+	assert.Equal(t, first, second)
+	assert.Equal(t, 1, compiler.compiles)
+
+	compiler.setFingerprint("changed")
+	third, err := cache.GetExecutableFromContext(context.Background(), c)
+	assert.NoError(t, err)
+	assert.NotEqual(t, first, third)
+	assert.Equal(t, 2, compiler.compiles)
+}
+
+type mockWatcher struct {
+	dirs map[string][]string
+	// err, if set, fails every Watch
+	err error
+}
+
+func (m *mockWatcher) Watch(c Context, dirs []string) error {
+	m.dirs[c.Key()] = dirs
+	return m.err
+}
+
+func (m *mockWatcher) Unwatch(c Context) {
+	delete(m.dirs, c.Key())
+}
+
+func TestWatcherFollowsBuilds(t *testing.T) {
+	dir := t.TempDir()
+	compiler := newMockCompiler()
+	cache := NewCache(dir, compiler)
+	watcher := &mockWatcher{dirs: make(map[string][]string)}
+	cache.SetWatcher(watcher)
+
+	c := Context{}
+	_, err := cache.GetExecutableFromContext(context.Background(), c)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"initial"}, watcher.dirs[c.Key()])
+
+	compiler.setFingerprint("changed")
+	err = cache.Recompile(context.Background(), c, Interactive)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"changed"}, watcher.dirs[c.Key()])
+}
+
+func TestTrustWatcherUntilChanged(t *testing.T) {
+	dir := t.TempDir()
+	compiler := newMockCompiler()
+	cache := NewCache(dir, compiler)
+	cache.SetWatcher(&mockWatcher{dirs: make(map[string][]string)})
+
+	c := Context{}
+	first, err := cache.GetExecutableFromContext(context.Background(), c)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, compiler.fingerprints)
+
+	// Without word from the watcher, the sources aren't fingerprinted again
+	compiler.setFingerprint("changed")
+	second, err := cache.GetExecutableFromContext(context.Background(), c)
+	assert.NoError(t, err)
+	assert.Equal(t, first, second)
+	assert.Equal(t, 1, compiler.fingerprints)
+	assert.Equal(t, 1, compiler.compiles)
+
+	cache.Changed(c)
+	third, err := cache.GetExecutableFromContext(context.Background(), c)
+	assert.NoError(t, err)
+	assert.NotEqual(t, first, third)
+	assert.Equal(t, 2, compiler.fingerprints)
+	assert.Equal(t, 2, compiler.compiles)
+
+	_, err = cache.GetExecutableFromContext(context.Background(), c)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, compiler.fingerprints)
+}
+
+func TestFingerprintWhenNotWatched(t *testing.T) {
+	dir := t.TempDir()
+	compiler := newMockCompiler()
+	cache := NewCache(dir, compiler)
+	cache.SetWatcher(&mockWatcher{dirs: make(map[string][]string), err: errors.New("too many watches")})
+
+	c := Context{}
+	for range 2 {
+		_, err := cache.GetExecutableFromContext(context.Background(), c)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 2, compiler.fingerprints)
+	assert.Equal(t, 1, compiler.compiles)
+}
+
+func TestRefreshOnlyWhenSourcesChange(t *testing.T) {
+	dir := t.TempDir()
+	compiler := newMockCompiler()
+	cache := NewCache(dir, compiler)
+
+	c := Context{}
+	first, err := cache.GetExecutableFromContext(context.Background(), c)
+	assert.NoError(t, err)
+
+	assert.NoError(t, cache.Refresh(context.Background(), c, Background))
+	assert.Equal(t, 1, compiler.compiles)
+
+	compiler.setFingerprint("changed")
+	assert.NoError(t, cache.Refresh(context.Background(), c, Background))
+	assert.Equal(t, 2, compiler.compiles)
+	second, err := cache.GetExecutableFromContext(context.Background(), c)
+	assert.NoError(t, err)
+	assert.NotEqual(t, first, second)
+	assert.Equal(t, 2, compiler.compiles)
+}
+
 func TestPreventSimultaneousCompilation(t *testing.T) {
 	dir := t.TempDir()
 	compiler := newMockCompiler()
@@ -72,7 +231,7 @@ func TestPreventSimultaneousCompilation(t *testing.T) {
 	// mock compiler should blow up if they are called simultaneously
 	for range 10 {
 		wg.Go(func() {
-			cache.GetExecutableFromContext(c)
+			cache.GetExecutableFromContext(context.Background(), c)
 		})
 	}
 	wg.Wait()
@@ -83,7 +242,15 @@ type blockingCompiler struct {
 	proceed chan struct{}
 }
 
-func (b *blockingCompiler) compile(c Context, outputFile string) error {
+func (b *blockingCompiler) sources(ctx context.Context, c Context) (sources, error) {
+	return sources{Fingerprint: "blocking"}, nil
+}
+
+func (b *blockingCompiler) resolve(ctx context.Context, c Context) (Context, error) {
+	return c, nil
+}
+
+func (b *blockingCompiler) compile(ctx context.Context, c Context, outputFile string) error {
 	// Signal that compile has started (and recompile already removed the file)
 	b.started <- struct{}{}
 
@@ -107,7 +274,7 @@ func TestReturnedPathRemainsUsableDuringRecompile(t *testing.T) {
 	// Allow the initial compile to finish
 	compiler.proceed <- struct{}{}
 
-	path, err := cache.GetExecutableFromContext(c)
+	path, err := cache.GetExecutableFromContext(context.Background(), c)
 	assert.NoError(t, err)
 
 	// Drain the "started" signal from the initial compile
@@ -115,7 +282,7 @@ func TestReturnedPathRemainsUsableDuringRecompile(t *testing.T) {
 
 	// Start a recompile AFTER the path is handed out
 	go func() {
-		_ = cache.Recompile(c)
+		_ = cache.Recompile(context.Background(), c, Interactive)
 	}()
 
 	// Wait until recompile has removed the file and is blocked in compile
@@ -124,3 +291,218 @@ func TestReturnedPathRemainsUsableDuringRecompile(t *testing.T) {
 	// Invariant: the returned path should still be usable
 	assert.FileExists(t, path)
 }
+
+// hangingCompiler never finishes a build until it is cancelled
+type hangingCompiler struct {
+	started   chan struct{}
+	cancelled chan struct{}
+}
+
+func newHangingCompiler() *hangingCompiler {
+	return &hangingCompiler{
+		started:   make(chan struct{}, 10),
+		cancelled: make(chan struct{}, 10),
+	}
+}
+
+func (h *hangingCompiler) sources(ctx context.Context, c Context) (sources, error) {
+	return sources{Fingerprint: "hanging"}, nil
+}
+
+func (h *hangingCompiler) resolve(ctx context.Context, c Context) (Context, error) {
+	return c, nil
+}
+
+func (h *hangingCompiler) compile(ctx context.Context, c Context, outputFile string) error {
+	h.started <- struct{}{}
+	<-ctx.Done()
+	h.cancelled <- struct{}{}
+	return ctx.Err()
+}
+
+func TestBuildTimeout(t *testing.T) {
+	compiler := newHangingCompiler()
+	cache := NewCache(t.TempDir(), compiler)
+	cache.SetBuildTimeout(50 * time.Millisecond)
+
+	c := Context{}
+	wg := sync.WaitGroup{}
+	for range 3 {
+		wg.Go(func() {
+			_, err := cache.GetExecutableFromContext(context.Background(), c)
+			assert.ErrorIs(t, err, ErrBuildTimeout)
+		})
+	}
+	wg.Wait()
+	assert.Len(t, compiler.cancelled, 1)
+
+	// The key is not stuck, a later request builds again
+	_, err := cache.GetExecutableFromContext(context.Background(), c)
+	assert.ErrorIs(t, err, ErrBuildTimeout)
+	assert.Len(t, compiler.started, 2)
+}
+
+func TestBuildCancelledWhenAllWaitersLeave(t *testing.T) {
+	compiler := newHangingCompiler()
+	cache := NewCache(t.TempDir(), compiler)
+	c := Context{}
+
+	firstCtx, cancelFirst := context.WithCancel(context.Background())
+	secondCtx, cancelSecond := context.WithCancel(context.Background())
+	errs := make(chan error, 2)
+	go func() {
+		_, err := cache.GetExecutableFromContext(firstCtx, c)
+		errs <- err
+	}()
+	<-compiler.started
+	go func() {
+		_, err := cache.GetExecutableFromContext(secondCtx, c)
+		errs <- err
+	}()
+	assert.Eventually(t, func() bool { return waiters(cache, c) == 2 }, time.Second, time.Millisecond)
+
+	cancelFirst()
+	assert.ErrorIs(t, <-errs, context.Canceled)
+	// Someone still wants the result, so the build carries on
+	assert.Never(t, func() bool { return len(compiler.cancelled) > 0 }, 50*time.Millisecond, 10*time.Millisecond)
+
+	cancelSecond()
+	assert.ErrorIs(t, <-errs, context.Canceled)
+	assert.Eventually(t, func() bool { return len(compiler.cancelled) == 1 }, time.Second, 10*time.Millisecond)
+}
+
+// waiters is the number of callers waiting on the build in progress for c
+func waiters(cache *Cache, c Context) int {
+	cache.mu.Lock()
+	e := cache.executables[c.Key()]
+	cache.mu.Unlock()
+	e.buildBarrier.Lock()
+	defer e.buildBarrier.Unlock()
+	if e.building == nil {
+		return 0
+	}
+	return e.building.waiters
+}
+
+func TestStaleWhileRebuilding(t *testing.T) {
+	compiler := newMockCompiler()
+	cache := NewCache(t.TempDir(), compiler)
+	c := Context{}
+	opts := Options{AllowStale: true}
+
+	first, err := cache.Get(context.Background(), c, opts)
+	assert.NoError(t, err)
+	assert.False(t, first.Stale)
+
+	compiler.setFingerprint("changed")
+	stale, err := cache.Get(context.Background(), c, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, Result{Path: first.Path, Stale: true, BuiltAt: first.BuiltAt}, stale)
+
+	assert.Eventually(t, func() bool { return !building(cache, c) }, time.Second, time.Millisecond)
+	fresh, err := cache.Get(context.Background(), c, opts)
+	assert.NoError(t, err)
+	assert.False(t, fresh.Stale)
+	assert.NotEqual(t, first.Path, fresh.Path)
+	assert.Equal(t, 2, compiler.compiles)
+}
+
+// building is true if a build is in progress for c
+func building(cache *Cache, c Context) bool {
+	cache.mu.Lock()
+	e := cache.executables[c.Key()]
+	cache.mu.Unlock()
+	e.buildBarrier.Lock()
+	defer e.buildBarrier.Unlock()
+	return e.building != nil
+}
+
+func TestFallbackToLastGoodBuild(t *testing.T) {
+	compiler := newMockCompiler()
+	cache := NewCache(t.TempDir(), compiler)
+	c := Context{}
+
+	good, err := cache.Get(context.Background(), c, Options{})
+	assert.NoError(t, err)
+	assert.False(t, good.BuiltAt.IsZero())
+
+	compileErr := &CompileError{Output: "broken"}
+	compiler.setFingerprint("broken")
+	compiler.setError(compileErr)
+	result, err := cache.Get(context.Background(), c, Options{})
+	assert.ErrorIs(t, err, compileErr)
+	assert.Equal(t, Result{}, result)
+
+	result, err = cache.Get(context.Background(), c, Options{Fallback: true})
+	assert.ErrorIs(t, err, compileErr)
+	assert.Equal(t, Result{Path: good.Path, Fallback: true, BuiltAt: good.BuiltAt}, result)
+}
+
+func TestRememberFailedBuild(t *testing.T) {
+	compiler := newMockCompiler()
+	cache := NewCache(t.TempDir(), compiler)
+	c := Context{}
+
+	// Failures that aren't down to the sources are always retried
+	compiler.setError(errors.New("out of disk space"))
+	_, err := cache.GetExecutableFromContext(context.Background(), c)
+	assert.Error(t, err)
+	compileErr := &CompileError{Output: "broken"}
+	compiler.setError(compileErr)
+	_, err = cache.GetExecutableFromContext(context.Background(), c)
+	assert.ErrorIs(t, err, compileErr)
+	assert.Equal(t, 2, compiler.compiles)
+
+	// The sources haven't changed, so the build would fail the same way
+	_, err = cache.GetExecutableFromContext(context.Background(), c)
+	assert.ErrorIs(t, err, compileErr)
+	assert.Equal(t, 2, compiler.compiles)
+
+	// Unless asked to retry anyway
+	_, err = cache.Get(context.Background(), c, Options{Retry: true})
+	assert.ErrorIs(t, err, compileErr)
+	assert.Equal(t, 3, compiler.compiles)
+
+	compiler.setError(nil)
+	compiler.setFingerprint("fixed")
+	_, err = cache.GetExecutableFromContext(context.Background(), c)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, compiler.compiles)
+}
+
+// countingCompiler counts the compiles of a real compiler
+type countingCompiler struct {
+	DefaultCompiler
+	compiles int
+}
+
+func (c *countingCompiler) compile(ctx context.Context, e Context, outputFile string) error {
+	c.compiles++
+	return c.DefaultCompiler.compile(ctx, e, outputFile)
+}
+
+func TestRememberLoadError(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":  "module example.com/broken\n\ngo 1.22\n",
+		"main.go": "func main() {}\n",
+	})
+	compiler := &countingCompiler{}
+	cache := NewCache(t.TempDir(), compiler)
+	c := Context{MainPackage: ".", Directory: dir}
+
+	var compileErr *CompileError
+	_, err := cache.GetExecutableFromContext(context.Background(), c)
+	assert.ErrorAs(t, err, &compileErr)
+	assert.Equal(t, 1, compiler.compiles)
+
+	// main.go still doesn't parse, so there's no point trying again
+	_, err = cache.GetExecutableFromContext(context.Background(), c)
+	assert.ErrorAs(t, err, &compileErr)
+	assert.Equal(t, 1, compiler.compiles)
+
+	writeFiles(t, dir, map[string]string{"main.go": "package main\n\nfunc main() {}\n"})
+	_, err = cache.GetExecutableFromContext(context.Background(), c)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, compiler.compiles)
+}
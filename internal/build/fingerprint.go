@@ -0,0 +1,181 @@
+package build
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/zeebo/xxh3"
+)
+
+// sources describes the inputs a build of a Context depends on
+type sources struct {
+	// Fingerprint changes whenever any input to the build changes
+	Fingerprint string
+	// Dirs are the directories holding every input outside of the module
+	// cache: the packages, any files they embed, and their go.mod files
+	Dirs []string
+}
+
+// listedModule is the subset of `go list -json` module output we care about
+type listedModule struct {
+	Path    string
+	Version string
+	GoMod   string
+	Replace *listedModule
+}
+
+// listedPackage is the subset of `go list -json` package output we care about
+type listedPackage struct {
+	ImportPath string
+	Dir        string
+	Standard   bool
+	Module     *listedModule
+
+	GoFiles      []string
+	CgoFiles     []string
+	CFiles       []string
+	CXXFiles     []string
+	MFiles       []string
+	HFiles       []string
+	FFiles       []string
+	SFiles       []string
+	SwigFiles    []string
+	SwigCXXFiles []string
+	SysoFiles    []string
+	EmbedFiles   []string
+}
+
+const listFields = "ImportPath,Dir,Standard,Module,GoFiles,CgoFiles,CFiles,CXXFiles,MFiles,HFiles,FFiles,SFiles,SwigFiles,SwigCXXFiles,SysoFiles,EmbedFiles"
+
+func (p *listedPackage) files() [][]string {
+	return [][]string{
+		p.GoFiles, p.CgoFiles, p.CFiles, p.CXXFiles, p.MFiles, p.HFiles, p.FFiles,
+		p.SFiles, p.SwigFiles, p.SwigCXXFiles, p.SysoFiles, p.EmbedFiles,
+	}
+}
+
+// fromModuleCache is true if the package's sources are immutable, i.e. they
+// come from a versioned module that has not been replaced with a local directory
+func (p *listedPackage) fromModuleCache() bool {
+	return p.Module != nil && p.Module.Version != "" && p.Module.Replace == nil
+}
+
+func (d *DefaultCompiler) sources(ctx context.Context, executableContext Context) (sources, error) {
+	if executableContext.remote() {
+		// Resolved module versions are immutable, so the name is the fingerprint
+		return sources{Fingerprint: hashBytes([]byte(executableContext.MainPackage))}, nil
+	}
+	args := []string{"list", "-e", "-deps", "-json=" + listFields}
+	args = append(args, executableContext.Flags.listArgs()...)
+	args = append(args, executableContext.MainPackage)
+	output, err := executableContext.goCommand(ctx, executableContext.Directory, args...)
+	if err != nil {
+		return sources{}, err
+	}
+	var packages []listedPackage
+	decoder := json.NewDecoder(strings.NewReader(output))
+	for {
+		var p listedPackage
+		err := decoder.Decode(&p)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return sources{}, fmt.Errorf("parsing go list output: %w", err)
+		}
+		packages = append(packages, p)
+	}
+	return fingerprintPackages(packages)
+}
+
+// fingerprintPackages hashes every input of the listed packages. Packages from
+// the standard library or the module cache are identified by their version alone.
+// Files are identified by their package and name rather than their path, so that
+// identical sources in different directories have the same fingerprint.
+func fingerprintPackages(packages []listedPackage) (sources, error) {
+	h := xxh3.New()
+	var dirs []string
+	seen := make(map[string]bool)
+	addDir := func(dir string) {
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	// go.mod files by module path
+	goMods := make(map[string]string)
+
+	for i := range packages {
+		p := &packages[i]
+		if p.Standard {
+			continue
+		}
+		fmt.Fprintf(h, "package %s\x00", p.ImportPath)
+		if p.fromModuleCache() {
+			fmt.Fprintf(h, "module %s@%s\x00", p.Module.Path, p.Module.Version)
+			continue
+		}
+		addDir(p.Dir)
+		for _, files := range p.files() {
+			for _, file := range files {
+				// Embedded files may be in subdirectories
+				path := filepath.Join(p.Dir, file)
+				addDir(filepath.Dir(path))
+				err := hashFile(h, path, file)
+				if err != nil {
+					return sources{}, err
+				}
+			}
+		}
+		if p.Module != nil {
+			goMod := p.Module.GoMod
+			if p.Module.Replace != nil {
+				goMod = p.Module.Replace.GoMod
+			}
+			if goMod != "" {
+				goMods[p.Module.Path] = goMod
+			}
+		}
+	}
+
+	for _, module := range slices.Sorted(maps.Keys(goMods)) {
+		goMod := goMods[module]
+		addDir(filepath.Dir(goMod))
+		for _, file := range []string{goMod, filepath.Join(filepath.Dir(goMod), "go.sum")} {
+			err := hashFile(h, file, module+"/"+filepath.Base(file))
+			if err != nil && !os.IsNotExist(err) {
+				return sources{}, err
+			}
+		}
+	}
+
+	sum := h.Sum128().Bytes()
+	return sources{
+		Fingerprint: hex.EncodeToString(sum[:]),
+		Dirs:        dirs,
+	}, nil
+}
+
+// hashFile writes the contents of the file at path, identified by name
+func hashFile(w io.Writer, path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "file %s %d\x00", name, info.Size())
+	_, err = io.Copy(w, f)
+	return err
+}
@@ -0,0 +1,113 @@
+package build
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+}
+
+func TestSourcesFingerprint(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod": "module example.com/tool\n\ngo 1.22\n",
+		"main.go": `package main
+
+import (
+	_ "embed"
+
+	"example.com/tool/lib"
+)
+
+//go:embed message.txt
+var message string
+
+func main() { println(message, lib.Name) }
+`,
+		"message.txt": "hello",
+		"lib/lib.go":  "package lib\n\nconst Name = \"lib\"\n",
+	})
+	c := Context{MainPackage: ".", Directory: dir}
+	compiler := &DefaultCompiler{}
+
+	fingerprint := func() string {
+		src, err := compiler.sources(context.Background(), c)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, src.Fingerprint)
+		return src.Fingerprint
+	}
+
+	initial := fingerprint()
+	assert.Equal(t, initial, fingerprint(), "fingerprint should be stable")
+
+	cases := []struct {
+		description string
+		file        string
+		content     string
+	}{
+		{"dependency changes", "lib/lib.go", "package lib\n\nconst Name = \"changed\"\n"},
+		{"embedded file changes", "message.txt", "goodbye"},
+		{"go.mod changes", "go.mod", "module example.com/tool\n\ngo 1.23\n"},
+		{"main package changes", "main.go", "package main\n\nfunc main() {}\n"},
+	}
+	seen := map[string]bool{initial: true}
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			writeFiles(t, dir, map[string]string{tc.file: tc.content})
+			current := fingerprint()
+			assert.False(t, seen[current], "fingerprint did not change")
+			seen[current] = true
+		})
+	}
+}
+
+func TestSourcesFingerprintIgnoresDirectory(t *testing.T) {
+	files := map[string]string{
+		"go.mod":  "module example.com/tool\n\ngo 1.22\n",
+		"main.go": "package main\n\nfunc main() {}\n",
+	}
+	compiler := &DefaultCompiler{}
+	var fingerprints []string
+	for _, dir := range []string{t.TempDir(), t.TempDir()} {
+		writeFiles(t, dir, files)
+		src, err := compiler.sources(context.Background(), Context{MainPackage: ".", Directory: dir})
+		assert.NoError(t, err)
+		fingerprints = append(fingerprints, src.Fingerprint)
+	}
+	assert.Equal(t, fingerprints[0], fingerprints[1])
+}
+
+func TestSourcesDirs(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod": "module example.com/tool\n\ngo 1.22\n",
+		"cmd/tool/main.go": `package main
+
+import _ "embed"
+
+//go:embed static/index.html
+var index string
+
+func main() { println(index) }
+`,
+		"cmd/tool/static/index.html": "hello",
+	})
+	src, err := (&DefaultCompiler{}).sources(context.Background(), Context{MainPackage: "./cmd/tool", Directory: dir})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		filepath.Join(dir, "cmd/tool"),
+		filepath.Join(dir, "cmd/tool/static"),
+		dir,
+	}, src.Dirs)
+}
@@ -0,0 +1,45 @@
+package build
+
+// Flags are the `go build` flags that can change the resulting executable
+type Flags struct {
+	Tags     string
+	LDFlags  string
+	GCFlags  string
+	Race     bool
+	TrimPath bool
+	Cover    bool
+	Mod      string
+}
+
+// Args are the arguments to pass to `go build`
+func (f Flags) Args() []string {
+	args := f.listArgs()
+	if f.LDFlags != "" {
+		args = append(args, "-ldflags="+f.LDFlags)
+	}
+	if f.GCFlags != "" {
+		args = append(args, "-gcflags="+f.GCFlags)
+	}
+	if f.Race {
+		args = append(args, "-race")
+	}
+	if f.TrimPath {
+		args = append(args, "-trimpath")
+	}
+	if f.Cover {
+		args = append(args, "-cover")
+	}
+	return args
+}
+
+// listArgs are the subset of Args that change which files `go list` selects
+func (f Flags) listArgs() []string {
+	var args []string
+	if f.Tags != "" {
+		args = append(args, "-tags="+f.Tags)
+	}
+	if f.Mod != "" {
+		args = append(args, "-mod="+f.Mod)
+	}
+	return args
+}
@@ -0,0 +1,57 @@
+package build
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlagsArgs(t *testing.T) {
+	cases := []struct {
+		description  string
+		flags        Flags
+		expectedArgs []string
+	}{
+		{
+			description:  "no flags",
+			flags:        Flags{},
+			expectedArgs: nil,
+		},
+		{
+			description: "all flags",
+			flags: Flags{
+				Tags:     "a,b",
+				LDFlags:  "-X main.version=1 -s",
+				GCFlags:  "all=-N -l",
+				Race:     true,
+				TrimPath: true,
+				Cover:    true,
+				Mod:      "vendor",
+			},
+			expectedArgs: []string{
+				"-tags=a,b",
+				"-mod=vendor",
+				"-ldflags=-X main.version=1 -s",
+				"-gcflags=all=-N -l",
+				"-race",
+				"-trimpath",
+				"-cover",
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			assert.Equal(t, tc.expectedArgs, tc.flags.Args())
+		})
+	}
+}
+
+func TestFlagsChangeKey(t *testing.T) {
+	plain := Context{MainPackage: ".", Directory: "/src"}
+	tagged := Context{MainPackage: ".", Directory: "/src", Flags: Flags{Tags: "debug"}}
+	raced := Context{MainPackage: ".", Directory: "/src", Flags: Flags{Race: true}}
+
+	assert.NotEqual(t, plain.Key(), tagged.Key())
+	assert.NotEqual(t, plain.Key(), raced.Key())
+	assert.NotEqual(t, tagged.Key(), raced.Key())
+}
@@ -0,0 +1,248 @@
+package build
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	log "github.com/lukemassa/clilog"
+)
+
+// Files used more recently than this are never collected, so that a client
+// that was just handed a path still has time to use it
+const CollectGrace = time.Minute
+
+// GCPolicy bounds the size of the cache directory. Zero values mean unlimited.
+type GCPolicy struct {
+	// MaxBytes bounds the total size of all binaries
+	MaxBytes int64
+	// MaxEntriesPerKey bounds the number of binaries kept for each context
+	MaxEntriesPerKey int
+	// MaxAge bounds how long a binary is kept after it was last used
+	MaxAge time.Duration
+}
+
+// GCResult summarizes a garbage collection
+type GCResult struct {
+	Removed int
+	Freed   int64
+}
+
+// LRUFile is a file that CollectLRU may remove
+type LRUFile struct {
+	Path string
+	// Size is the space only this file holds, which is none for a file that
+	// is also linked from elsewhere
+	Size     int64
+	LastUsed time.Time
+	// Key groups files for GCPolicy.MaxEntriesPerKey, which does not apply to
+	// files without one
+	Key string
+	// Shared files are used whenever a file that refers to them is, so among
+	// files last used at the same time they are removed last
+	Shared bool
+}
+
+// isHexName is true for the names the cache generates for keys and binaries
+func isHexName(name string) bool {
+	_, err := hex.DecodeString(name)
+	return err == nil && len(name) == 32
+}
+
+// binaries lists the binaries on disk, see Cache.compile for the layout.
+// Binaries in the object store are Shared and have no Key.
+func (s *Cache) binaries() ([]LRUFile, error) {
+	keys, err := os.ReadDir(s.cacheDir)
+	if err != nil {
+		return nil, err
+	}
+	var entries []LRUFile
+	for _, key := range keys {
+		if !key.IsDir() || !(isHexName(key.Name()) || key.Name() == objectsDir) {
+			continue
+		}
+		files, err := os.ReadDir(filepath.Join(s.cacheDir, key.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if !isHexName(file.Name()) {
+				continue
+			}
+			info, err := file.Info()
+			if err != nil {
+				// Removed since the directory was read
+				continue
+			}
+			entry := LRUFile{
+				Key:      key.Name(),
+				Path:     filepath.Join(s.cacheDir, key.Name(), file.Name()),
+				Size:     info.Size(),
+				LastUsed: info.ModTime(),
+			}
+			if key.Name() == objectsDir {
+				entry.Key = ""
+				entry.Shared = true
+			} else if links(info) > 1 {
+				entry.Size = 0
+			}
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// compareLastUsed orders files by when they were last used, in the given
+// direction, with Shared files after the others among equals
+func compareLastUsed(mostRecentFirst bool) func(a, b LRUFile) int {
+	return func(a, b LRUFile) int {
+		c := a.LastUsed.Compare(b.LastUsed)
+		if mostRecentFirst {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+		switch {
+		case a.Shared == b.Shared:
+			return 0
+		case b.Shared:
+			return -1
+		default:
+			return 1
+		}
+	}
+}
+
+// CollectLRU removes files until they satisfy the policy, least recently used
+// first, by calling remove, which reports whether it removed the file. Files
+// used within CollectGrace are never removed.
+func CollectLRU(files []LRUFile, policy GCPolicy, remove func(LRUFile) bool) GCResult {
+	files = slices.Clone(files)
+	slices.SortFunc(files, compareLastUsed(true))
+
+	now := time.Now()
+	tryRemove := func(f LRUFile) bool {
+		return now.Sub(f.LastUsed) >= CollectGrace && remove(f)
+	}
+	var result GCResult
+	var total int64
+	perKey := make(map[string]int)
+	var kept []LRUFile
+	for _, f := range files {
+		perKey[f.Key]++
+		expired := policy.MaxAge > 0 && now.Sub(f.LastUsed) > policy.MaxAge
+		excess := policy.MaxEntriesPerKey > 0 && f.Key != "" && perKey[f.Key] > policy.MaxEntriesPerKey
+		if (expired || excess) && tryRemove(f) {
+			result.Removed++
+			result.Freed += f.Size
+			continue
+		}
+		total += f.Size
+		kept = append(kept, f)
+	}
+
+	slices.SortFunc(kept, compareLastUsed(false))
+	for _, f := range kept {
+		if policy.MaxBytes <= 0 || total <= policy.MaxBytes {
+			break
+		}
+		if tryRemove(f) {
+			result.Removed++
+			result.Freed += f.Size
+			total -= f.Size
+		}
+	}
+	return result
+}
+
+// Collect removes binaries from the cache directory until it satisfies the
+// policy, see CollectLRU. Stored objects that some context still links to are
+// never removed.
+func (s *Cache) Collect(policy GCPolicy) (GCResult, error) {
+	entries, err := s.binaries()
+	if err != nil {
+		return GCResult{}, err
+	}
+	result := CollectLRU(entries, policy, s.remove)
+	s.removeEmptyKeyDirs()
+	log.Infof("Garbage collection removed %d binaries, freeing %d bytes", result.Removed, result.Freed)
+	return result, nil
+}
+
+// remove deletes a binary, returning whether it did so. If the binary is the
+// current one for its context, the context leaves the cache.
+func (s *Cache) remove(entry LRUFile) bool {
+	if entry.Shared {
+		return s.removeObject(entry)
+	}
+	s.mu.Lock()
+	e, ok := s.executables[entry.Key]
+	s.mu.Unlock()
+
+	if ok {
+		// Don't wait behind a build, just try again next time
+		if !e.buildBarrier.TryLock() {
+			return false
+		}
+		defer e.buildBarrier.Unlock()
+		if e.currentPath == entry.Path {
+			if e.failed {
+				// Kept as the fallback until a build succeeds
+				return false
+			}
+			info, err := os.Stat(entry.Path)
+			if err == nil && time.Since(info.ModTime()) < CollectGrace {
+				// Handed out since we listed it
+				return false
+			}
+			s.forget(entry.Key, e)
+		}
+	}
+
+	err := os.Remove(entry.Path)
+	if err != nil && !os.IsNotExist(err) {
+		log.Warnf("Failed to remove %s: %v", entry.Path, err)
+		return false
+	}
+	return true
+}
+
+// removeObject deletes a binary from the object store, unless a context still
+// links to it
+func (s *Cache) removeObject(entry LRUFile) bool {
+	info, err := os.Stat(entry.Path)
+	if err != nil || links(info) > 1 {
+		return false
+	}
+	err = os.Remove(entry.Path)
+	if err != nil {
+		log.Warnf("Failed to remove %s: %v", entry.Path, err)
+		return false
+	}
+	return true
+}
+
+// removeEmptyKeyDirs cleans up the directories of keys that are not in use
+func (s *Cache) removeEmptyKeyDirs() {
+	keys, err := os.ReadDir(s.cacheDir)
+	if err != nil {
+		log.Warnf("Failed to read %s: %v", s.cacheDir, err)
+		return
+	}
+	// Holding the lock means no build can start for an untracked key meanwhile
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range keys {
+		if !key.IsDir() || !isHexName(key.Name()) {
+			continue
+		}
+		if _, ok := s.executables[key.Name()]; ok {
+			continue
+		}
+		// Fails harmlessly if the directory is not empty
+		_ = os.Remove(filepath.Join(s.cacheDir, key.Name()))
+	}
+}
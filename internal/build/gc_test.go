@@ -0,0 +1,171 @@
+package build
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeBinary creates a fake binary in the cache last used the given time ago
+func writeBinary(t *testing.T, cacheDir, key, name string, size int, age time.Duration) string {
+	t.Helper()
+	path := filepath.Join(cacheDir, key, name)
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0o700))
+	assert.NoError(t, os.WriteFile(path, []byte(strings.Repeat("x", size)), 0o700))
+	lastUsed := time.Now().Add(-age)
+	assert.NoError(t, os.Chtimes(path, lastUsed, lastUsed))
+	return path
+}
+
+const (
+	keyA = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	keyB = "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+)
+
+func TestCollect(t *testing.T) {
+	cases := []struct {
+		description     string
+		policy          GCPolicy
+		expectedRemoved []string
+	}{
+		{
+			description:     "no limits",
+			policy:          GCPolicy{},
+			expectedRemoved: nil,
+		},
+		{
+			description:     "max age",
+			policy:          GCPolicy{MaxAge: 90 * time.Minute},
+			expectedRemoved: []string{"a3", "b2"},
+		},
+		{
+			description:     "max entries per key",
+			policy:          GCPolicy{MaxEntriesPerKey: 1},
+			expectedRemoved: []string{"a2", "a3", "b2"},
+		},
+		{
+			description:     "max bytes removes least recently used",
+			policy:          GCPolicy{MaxBytes: 25},
+			expectedRemoved: []string{"a3", "b2", "a2"},
+		},
+		{
+			description:     "recently used binaries are never removed",
+			policy:          GCPolicy{MaxBytes: 1},
+			expectedRemoved: []string{"a3", "b2", "a2", "b1"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			dir := t.TempDir()
+			paths := map[string]string{
+				"a1": writeBinary(t, dir, keyA, "a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1", 10, 0),
+				"a2": writeBinary(t, dir, keyA, "a2a2a2a2a2a2a2a2a2a2a2a2a2a2a2a2", 10, time.Hour),
+				"a3": writeBinary(t, dir, keyA, "a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3a3", 10, 3*time.Hour),
+				"b1": writeBinary(t, dir, keyB, "b1b1b1b1b1b1b1b1b1b1b1b1b1b1b1b1", 10, 30*time.Minute),
+				"b2": writeBinary(t, dir, keyB, "b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2", 10, 2*time.Hour),
+			}
+			cache := NewCache(dir, newMockCompiler())
+
+			result, err := cache.Collect(tc.policy)
+			assert.NoError(t, err)
+			assert.Equal(t, len(tc.expectedRemoved), result.Removed)
+			assert.Equal(t, int64(10*len(tc.expectedRemoved)), result.Freed)
+			for name, path := range paths {
+				if slices.Contains(tc.expectedRemoved, name) {
+					assert.NoFileExists(t, path)
+				} else {
+					assert.FileExists(t, path)
+				}
+			}
+		})
+	}
+}
+
+func TestCollectEvictsCurrentBinary(t *testing.T) {
+	dir := t.TempDir()
+	compiler := newMockCompiler()
+	cache := NewCache(dir, compiler)
+	watcher := &mockWatcher{dirs: make(map[string][]string)}
+	cache.SetWatcher(watcher)
+
+	c := Context{}
+	path, err := cache.GetExecutableFromContext(context.Background(), c)
+	assert.NoError(t, err)
+
+	// Just handed out, so it is protected
+	_, err = cache.Collect(GCPolicy{MaxAge: time.Nanosecond})
+	assert.NoError(t, err)
+	assert.FileExists(t, path)
+	assert.Contains(t, watcher.dirs, c.Key())
+
+	longAgo := time.Now().Add(-time.Hour)
+	assert.NoError(t, os.Chtimes(path, longAgo, longAgo))
+	_, err = cache.Collect(GCPolicy{MaxAge: time.Minute})
+	assert.NoError(t, err)
+	assert.NoFileExists(t, path)
+	assert.NotContains(t, watcher.dirs, c.Key())
+
+	// The context left the cache, along with the stored object, so it is rebuilt
+	newPath, err := cache.GetExecutableFromContext(context.Background(), c)
+	assert.NoError(t, err)
+	assert.FileExists(t, newPath)
+	assert.Equal(t, 2, compiler.compiles)
+}
+
+func TestCollectKeepsFallback(t *testing.T) {
+	compiler := newMockCompiler()
+	cache := NewCache(t.TempDir(), compiler)
+
+	c := Context{}
+	path, err := cache.GetExecutableFromContext(context.Background(), c)
+	assert.NoError(t, err)
+
+	compiler.setError(&CompileError{Output: "broken"})
+	assert.Error(t, cache.Recompile(context.Background(), c, Interactive))
+
+	longAgo := time.Now().Add(-time.Hour)
+	assert.NoError(t, os.Chtimes(path, longAgo, longAgo))
+	_, err = cache.Collect(GCPolicy{MaxAge: time.Minute})
+	assert.NoError(t, err)
+	assert.FileExists(t, path)
+
+	// Once a build succeeds, the old binary is no longer needed
+	compiler.setError(nil)
+	compiler.setFingerprint("fixed")
+	assert.NoError(t, cache.Recompile(context.Background(), c, Interactive))
+	_, err = cache.Collect(GCPolicy{MaxAge: time.Minute})
+	assert.NoError(t, err)
+	assert.NoFileExists(t, path)
+}
+
+func TestCollectSharedObject(t *testing.T) {
+	dir := t.TempDir()
+	compiler := newMockCompiler()
+	cache := NewCache(dir, compiler)
+
+	first, err := cache.GetExecutableFromContext(context.Background(), Context{Directory: "/one", Flags: Flags{TrimPath: true}})
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(first, []byte("0123456789"), 0o700))
+	second, err := cache.GetExecutableFromContext(context.Background(), Context{Directory: "/two", Flags: Flags{TrimPath: true}})
+	assert.NoError(t, err)
+	longAgo := time.Now().Add(-time.Hour)
+	assert.NoError(t, os.Chtimes(first, longAgo, longAgo))
+
+	// The shared binary only counts once
+	result, err := cache.Collect(GCPolicy{MaxBytes: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, GCResult{}, result)
+
+	result, err = cache.Collect(GCPolicy{MaxBytes: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, GCResult{Removed: 3, Freed: 10}, result)
+	assert.NoFileExists(t, first)
+	assert.NoFileExists(t, second)
+	assert.NoFileExists(t, filepath.Join(dir, objectsDir, filepath.Base(first)))
+}
@@ -0,0 +1,125 @@
+package build
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry describes a context in the cache, see List
+type Entry struct {
+	Context Context
+	// Path is the current binary, empty if the context has never built
+	Path string
+	// Size of Path in bytes
+	Size int64
+	// BuiltAt is when Path was built
+	BuiltAt time.Time
+	// BuildDuration is how long Path took to build, zero if it was reused
+	BuildDuration time.Duration
+	// Hits counts the requests Path has answered
+	Hits int
+	// LastUsed is when Path was last handed out
+	LastUsed time.Time
+	// Stale is true if the sources have changed since Path was built
+	Stale bool
+	// Failed is true if the latest build failed, so Path is older
+	Failed bool
+	// Building is true if a build is in progress
+	Building bool
+}
+
+// List returns the contexts in the cache, ordered by package and directory.
+// Working out which are stale means fingerprinting each context's sources.
+func (s *Cache) List(ctx context.Context) []Entry {
+	var entries []Entry
+	var fingerprints []string
+	for _, e := range s.snapshot() {
+		e.buildBarrier.Lock()
+		entry := Entry{
+			Context:       e.context,
+			Path:          e.currentPath,
+			BuiltAt:       e.builtAt,
+			BuildDuration: e.buildDuration,
+			Hits:          e.hits,
+			Failed:        e.failed,
+			Building:      e.building != nil,
+		}
+		fingerprint := e.fingerprint
+		e.buildBarrier.Unlock()
+		if entry.Path == "" && !entry.Building {
+			continue
+		}
+		if info, err := os.Stat(entry.Path); err == nil {
+			entry.Size = info.Size()
+			entry.LastUsed = info.ModTime()
+		}
+		entries = append(entries, entry)
+		fingerprints = append(fingerprints, fingerprint)
+	}
+
+	// Each check runs the go command, so only run as many at once as there
+	// are CPUs to run them
+	limit := make(chan struct{}, runtime.GOMAXPROCS(0))
+	var wg sync.WaitGroup
+	for i := range entries {
+		if entries[i].Path == "" {
+			continue
+		}
+		limit <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-limit }()
+			sourcesCtx, cancel := s.withBuildTimeout(ctx)
+			defer cancel()
+			src := s.sources(sourcesCtx, entries[i].Context)
+			entries[i].Stale = src.Fingerprint == "" || src.Fingerprint != fingerprints[i]
+		})
+	}
+	wg.Wait()
+
+	slices.SortFunc(entries, func(a, b Entry) int {
+		return cmpContexts(a.Context, b.Context)
+	})
+	return entries
+}
+
+// Count returns the number of contexts in the cache, and how many of them are
+// being built, more cheaply than List
+func (s *Cache) Count() (total, building int) {
+	for _, e := range s.snapshot() {
+		e.buildBarrier.Lock()
+		if e.building != nil {
+			building++
+		}
+		if e.currentPath != "" || e.building != nil {
+			total++
+		}
+		e.buildBarrier.Unlock()
+	}
+	return total, building
+}
+
+// snapshot returns the executables in the cache
+func (s *Cache) snapshot() []*executable {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	executables := make([]*executable, 0, len(s.executables))
+	for _, e := range s.executables {
+		executables = append(executables, e)
+	}
+	return executables
+}
+
+func cmpContexts(a, b Context) int {
+	if c := strings.Compare(a.MainPackage, b.MainPackage); c != 0 {
+		return c
+	}
+	if c := strings.Compare(a.Directory, b.Directory); c != 0 {
+		return c
+	}
+	return strings.Compare(a.Key(), b.Key())
+}
@@ -0,0 +1,41 @@
+package build
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestList(t *testing.T) {
+	compiler := newMockCompiler()
+	cache := NewCache(t.TempDir(), compiler)
+	b := Context{MainPackage: "b"}
+	a := Context{MainPackage: "a"}
+
+	for _, c := range []Context{b, a, a} {
+		_, err := cache.GetExecutableFromContext(context.Background(), c)
+		assert.NoError(t, err)
+	}
+	path, err := cache.GetExecutableFromContext(context.Background(), a)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(path, []byte("0123456789"), 0o700))
+
+	entries := cache.List(context.Background())
+	assert.Len(t, entries, 2)
+	assert.Equal(t, a, entries[0].Context)
+	assert.Equal(t, path, entries[0].Path)
+	assert.Equal(t, int64(10), entries[0].Size)
+	assert.Equal(t, 2, entries[0].Hits)
+	assert.Positive(t, entries[0].BuildDuration)
+	assert.False(t, entries[0].BuiltAt.IsZero())
+	assert.False(t, entries[0].LastUsed.IsZero())
+	assert.False(t, entries[0].Stale)
+	assert.Equal(t, b, entries[1].Context)
+
+	compiler.setFingerprint("changed")
+	for _, entry := range cache.List(context.Background()) {
+		assert.True(t, entry.Stale)
+	}
+}
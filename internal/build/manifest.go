@@ -0,0 +1,201 @@
+package build
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/lukemassa/clilog"
+)
+
+const manifestFile = "manifest.json"
+
+// How long changes that only cost a little if lost, such as hit counts, wait
+// before the manifest is saved, so that a run of hits saves it once
+const manifestSaveDelay = 5 * time.Second
+
+// manifestEntry is what is persisted about each context in the cache
+type manifestEntry struct {
+	Key         string
+	Context     Context
+	Fingerprint string
+	Dirs        []string
+	Path        string
+	BuiltAt     time.Time
+	// BuildDuration is how long Path took to build, zero if it was reused
+	BuildDuration time.Duration `json:",omitempty"`
+	Hits          int
+	Failed        bool `json:",omitempty"`
+}
+
+// manifest persists the cache index to disk, so that it survives restarts
+type manifest struct {
+	path string
+
+	mu    sync.Mutex
+	byKey map[string]manifestEntry
+	// pending is set while changes are waiting for a delayed save
+	pending *time.Timer
+	saveMu  sync.Mutex
+}
+
+func newManifest(path string) manifest {
+	return manifest{
+		path:  path,
+		byKey: make(map[string]manifestEntry),
+	}
+}
+
+func (m *manifest) entries() []manifestEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entries := make([]manifestEntry, 0, len(m.byKey))
+	for _, entry := range m.byKey {
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func (m *manifest) set(entry manifestEntry) {
+	m.mu.Lock()
+	m.byKey[entry.Key] = entry
+	m.mu.Unlock()
+	m.save()
+}
+
+// setLater is set, but saves the manifest after manifestSaveDelay instead of
+// straight away
+func (m *manifest) setLater(entry manifestEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.byKey[entry.Key] = entry
+	if m.pending == nil {
+		m.pending = time.AfterFunc(manifestSaveDelay, m.save)
+	}
+}
+
+// flush saves any changes waiting for a delayed save
+func (m *manifest) flush() {
+	m.mu.Lock()
+	pending := m.pending != nil
+	m.mu.Unlock()
+	if pending {
+		m.save()
+	}
+}
+
+func (m *manifest) remove(key string) {
+	m.mu.Lock()
+	delete(m.byKey, key)
+	m.mu.Unlock()
+	m.save()
+}
+
+// save writes the manifest atomically. Failing to save only costs rebuilds
+// after a restart, so errors are logged rather than returned.
+func (m *manifest) save() {
+	m.saveMu.Lock()
+	defer m.saveMu.Unlock()
+
+	m.mu.Lock()
+	if m.pending != nil {
+		// This save includes the pending changes
+		m.pending.Stop()
+		m.pending = nil
+	}
+	content, err := json.MarshalIndent(m.byKey, "", "  ")
+	m.mu.Unlock()
+	if err != nil {
+		log.Warnf("Failed to encode manifest: %v", err)
+		return
+	}
+	tmp := m.path + ".tmp"
+	err = os.WriteFile(tmp, content, 0o600)
+	if err == nil {
+		err = os.Rename(tmp, m.path)
+	}
+	if err != nil {
+		log.Warnf("Failed to save manifest: %v", err)
+	}
+}
+
+// record persists the state of e. Must be called with e's buildBarrier held.
+func (s *Cache) record(key string, e *executable) {
+	s.manifest.set(s.manifestEntry(key, e))
+}
+
+// recordHit persists the state of e after a cache hit, which is cheap to
+// lose, so it is saved with the next change or after manifestSaveDelay rather
+// than holding up the hit. Must be called with e's buildBarrier held.
+func (s *Cache) recordHit(key string, e *executable) {
+	s.manifest.setLater(s.manifestEntry(key, e))
+}
+
+// Flush saves any changes to the manifest not yet saved, such as recent hits.
+// Call it before the daemon exits.
+func (s *Cache) Flush() {
+	s.manifest.flush()
+}
+
+// manifestEntry is what is persisted about e. Must be called with e's
+// buildBarrier held.
+func (s *Cache) manifestEntry(key string, e *executable) manifestEntry {
+	return manifestEntry{
+		Key:           key,
+		Context:       e.context,
+		Fingerprint:   e.fingerprint,
+		Dirs:          e.dirs,
+		Path:          e.currentPath,
+		BuiltAt:       e.builtAt,
+		BuildDuration: e.buildDuration,
+		Hits:          e.hits,
+		Failed:        e.failed,
+	}
+}
+
+// Load restores the cache from the manifest written by a previous run,
+// skipping any entries whose binary no longer exists
+func (s *Cache) Load() error {
+	content, err := os.ReadFile(s.manifest.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var byKey map[string]manifestEntry
+	err = json.Unmarshal(content, &byKey)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.manifest.mu.Lock()
+	defer s.manifest.mu.Unlock()
+	for key, entry := range byKey {
+		if key != entry.Key || entry.Context.Key() != key {
+			log.Warnf("Ignoring manifest entry %s, its key is out of date", key)
+			continue
+		}
+		if _, err := os.Stat(entry.Path); err != nil {
+			log.Warnf("Ignoring manifest entry for %+v: %v", entry.Context, err)
+			continue
+		}
+		s.executables[key] = &executable{
+			context:       entry.Context,
+			currentPath:   entry.Path,
+			fingerprint:   entry.Fingerprint,
+			dirs:          entry.Dirs,
+			builtAt:       entry.BuiltAt,
+			buildDuration: entry.BuildDuration,
+			hits:          entry.Hits,
+			failed:        entry.Failed,
+		}
+		s.manifest.byKey[key] = entry
+	}
+	log.Infof("Loaded %d entries from %s", len(s.manifest.byKey), s.manifest.path)
+	return nil
+}
@@ -0,0 +1,69 @@
+package build
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadRestoresCache(t *testing.T) {
+	dir := t.TempDir()
+	first := Context{MainPackage: "./first"}
+	second := Context{MainPackage: "./second"}
+
+	original := NewCache(dir, newMockCompiler())
+	firstPath, err := original.GetExecutableFromContext(context.Background(), first)
+	assert.NoError(t, err)
+	_, err = original.GetExecutableFromContext(context.Background(), first)
+	assert.NoError(t, err)
+	secondPath, err := original.GetExecutableFromContext(context.Background(), second)
+	assert.NoError(t, err)
+	original.Flush()
+
+	// A binary that disappears while the daemon is down must not be served
+	assert.NoError(t, os.Remove(secondPath))
+	assert.NoError(t, os.RemoveAll(filepath.Join(dir, objectsDir)))
+
+	compiler := newMockCompiler()
+	restarted := NewCache(dir, compiler)
+	assert.NoError(t, restarted.Load())
+
+	path, err := restarted.GetExecutableFromContext(context.Background(), first)
+	assert.NoError(t, err)
+	assert.Equal(t, firstPath, path)
+	assert.Equal(t, 0, compiler.compiles)
+	assert.Equal(t, 2, restarted.executables[first.Key()].hits)
+
+	path, err = restarted.GetExecutableFromContext(context.Background(), second)
+	assert.NoError(t, err)
+	assert.FileExists(t, path)
+	assert.Equal(t, 1, compiler.compiles)
+}
+
+func TestLoadWithoutManifest(t *testing.T) {
+	cache := NewCache(t.TempDir(), newMockCompiler())
+	assert.NoError(t, cache.Load())
+	assert.Empty(t, cache.executables)
+}
+
+func TestHitsAreSavedLater(t *testing.T) {
+	dir := t.TempDir()
+	c := Context{MainPackage: "./tool"}
+	cache := NewCache(dir, newMockCompiler())
+	_, err := cache.GetExecutableFromContext(context.Background(), c)
+	assert.NoError(t, err)
+	_, err = cache.GetExecutableFromContext(context.Background(), c)
+	assert.NoError(t, err)
+
+	savedHits := func() int {
+		restarted := NewCache(dir, newMockCompiler())
+		assert.NoError(t, restarted.Load())
+		return restarted.executables[c.Key()].hits
+	}
+	assert.Equal(t, 0, savedHits())
+	cache.Flush()
+	assert.Equal(t, 1, savedHits())
+}
@@ -0,0 +1,99 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+)
+
+type EventKind string
+
+const (
+	// EventQueued means the build is waiting for a free build slot
+	EventQueued EventKind = "queued"
+	// EventBuilding means the go command is running
+	EventBuilding EventKind = "building"
+	// EventOutput is a line of output from the go command
+	EventOutput EventKind = "output"
+)
+
+// Event describes the progress of a build, for reporting to whoever is waiting on it
+type Event struct {
+	Kind EventKind
+	// QueuePosition is the 1-based position in the build queue, for EventQueued
+	QueuePosition int `json:",omitempty"`
+	// Line is the line of output, for EventOutput
+	Line string `json:",omitempty"`
+}
+
+// Reporter receives the events of any build a request waits on
+type Reporter func(Event)
+
+type reporterKey struct{}
+
+// WithReporter returns a context that reports build progress to r
+func WithReporter(ctx context.Context, r Reporter) context.Context {
+	return context.WithValue(ctx, reporterKey{}, r)
+}
+
+func report(ctx context.Context, event Event) {
+	r, ok := ctx.Value(reporterKey{}).(Reporter)
+	if ok {
+		r(event)
+	}
+}
+
+type outputKey struct{}
+
+// withOutput returns a context whose go commands pass each line of their
+// output to f as well as capturing it
+func withOutput(ctx context.Context, f func(line string)) context.Context {
+	return context.WithValue(ctx, outputKey{}, f)
+}
+
+// outputLines returns a writer that passes each line written to it to the
+// function from withOutput, if any. Flush passes on any final partial line.
+func outputLines(ctx context.Context) *lineWriter {
+	f, _ := ctx.Value(outputKey{}).(func(string))
+	return &lineWriter{f: f}
+}
+
+// lineWriter splits what is written to it into lines
+type lineWriter struct {
+	f   func(string)
+	mu  sync.Mutex
+	buf []byte
+}
+
+var _ io.Writer = (*lineWriter)(nil)
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	if w.f == nil {
+		return len(p), nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.f(string(w.buf[:i]))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (w *lineWriter) Flush() {
+	if w.f == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) > 0 {
+		w.f(string(w.buf))
+		w.buf = nil
+	}
+}
@@ -0,0 +1,37 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// chattyCompiler writes output as it compiles, the way the go command does
+type chattyCompiler struct {
+	*mockCompiler
+}
+
+func (c chattyCompiler) compile(ctx context.Context, bc Context, outputPath string) error {
+	lines := outputLines(ctx)
+	fmt.Fprint(lines, "# example\nfirst line\nsecond")
+	fmt.Fprint(lines, " line")
+	lines.Flush()
+	return c.mockCompiler.compile(ctx, bc, outputPath)
+}
+
+func TestCacheReportsOutput(t *testing.T) {
+	cache := NewCache(t.TempDir(), chattyCompiler{newMockCompiler()})
+
+	var events []Event
+	ctx := WithReporter(context.Background(), func(event Event) { events = append(events, event) })
+	_, err := cache.GetExecutableFromContext(ctx, Context{MainPackage: "example"})
+	assert.NoError(t, err)
+	assert.Equal(t, []Event{
+		{Kind: EventBuilding},
+		{Kind: EventOutput, Line: "# example"},
+		{Kind: EventOutput, Line: "first line"},
+		{Kind: EventOutput, Line: "second line"},
+	}, events)
+}
@@ -0,0 +1,80 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// exactVersion matches module versions that can never change meaning,
+// including pseudo-versions, as opposed to queries like "latest" or "v1"
+var exactVersion = regexp.MustCompile(`^v\d+\.\d+\.\d+(-[0-9A-Za-z.-]+)?(\+incompatible)?$`)
+
+// remote is true for contexts that name a package at a module version, such
+// as golang.org/x/tools/cmd/stringer@v0.20.0. Like `go install pkg@version`,
+// these are built outside of any local module.
+func (e Context) remote() bool {
+	return strings.Contains(e.MainPackage, "@")
+}
+
+// remoteEnviron is the environment for go commands on a remote context,
+// ensuring that no local module or workspace is involved
+func (e Context) remoteEnviron() []string {
+	return append(e.environ(), "GO111MODULE=on", "GOWORK=off")
+}
+
+// resolveRemote pins the version of a remote context, so that queries such as
+// @latest share a cache entry with the exact version they resolve to
+func (d *DefaultCompiler) resolveRemote(ctx context.Context, executableContext Context) (Context, error) {
+	resolved := executableContext
+	resolved.Directory = ""
+	pkg, query, _ := strings.Cut(executableContext.MainPackage, "@")
+	if exactVersion.MatchString(query) {
+		return resolved, nil
+	}
+
+	// The module is some prefix of the package path, so try the longest first
+	var err error
+	for modulePath := pkg; modulePath != "." && modulePath != "/"; modulePath = path.Dir(modulePath) {
+		var version string
+		version, err = executableContext.goCommandEnv(ctx, os.TempDir(), executableContext.remoteEnviron(),
+			"list", "-m", "-f", "{{.Version}}", modulePath+"@"+query)
+		if err == nil {
+			resolved.MainPackage = pkg + "@" + version
+			return resolved, nil
+		}
+	}
+	return Context{}, fmt.Errorf("resolving %s: %w", executableContext.MainPackage, err)
+}
+
+// compileRemote builds the way `go install pkg@version` does, then moves the
+// installed binary to outputFile
+func (d *DefaultCompiler) compileRemote(ctx context.Context, executableContext Context, outputFile string) error {
+	bin, err := os.MkdirTemp(filepath.Dir(outputFile), "install-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(bin)
+
+	args := []string{"install"}
+	args = append(args, executableContext.Flags.Args()...)
+	args = append(args, executableContext.MainPackage)
+	env := append(executableContext.remoteEnviron(), "GOBIN="+bin)
+	err = executableContext.goBuild(ctx, os.TempDir(), env, args)
+	if err != nil {
+		return err
+	}
+
+	installed, err := os.ReadDir(bin)
+	if err != nil {
+		return err
+	}
+	if len(installed) != 1 {
+		return fmt.Errorf("expected go install to produce one binary, found %d", len(installed))
+	}
+	return os.Rename(filepath.Join(bin, installed[0].Name()), outputFile)
+}
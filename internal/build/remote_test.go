@@ -0,0 +1,33 @@
+package build
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExactVersion(t *testing.T) {
+	cases := []struct {
+		version string
+		exact   bool
+	}{
+		{"v0.20.0", true},
+		{"v1.2.3-rc.1", true},
+		{"v0.0.0-20240101000000-abcdefabcdef", true},
+		{"v2.0.0+incompatible", true},
+		{"latest", false},
+		{"v1", false},
+		{"v1.2", false},
+		{"master", false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.version, func(t *testing.T) {
+			assert.Equal(t, tc.exact, exactVersion.MatchString(tc.version))
+		})
+	}
+}
+
+func TestRemoteContext(t *testing.T) {
+	assert.True(t, Context{MainPackage: "golang.org/x/tools/cmd/stringer@v0.20.0"}.remote())
+	assert.False(t, Context{MainPackage: "./cmd/tool"}.remote())
+}
@@ -0,0 +1,141 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Resolve canonicalizes a context, so that every way of naming the same
+// package from anywhere inside its module results in the same Key
+func (s *Cache) Resolve(ctx context.Context, executableContext Context) (Context, error) {
+	ctx, cancel := s.withBuildTimeout(ctx)
+	defer cancel()
+	return s.compiler.resolve(ctx, executableContext)
+}
+
+func (d *DefaultCompiler) resolve(ctx context.Context, executableContext Context) (Context, error) {
+	var resolved Context
+	var err error
+	if executableContext.remote() {
+		resolved, err = d.resolveRemote(ctx, executableContext)
+	} else {
+		resolved, err = d.resolveLocal(ctx, executableContext)
+	}
+	if err != nil {
+		return Context{}, err
+	}
+	resolved.GoVersion, err = resolved.goVersion(ctx)
+	if err != nil {
+		return Context{}, err
+	}
+	return resolved, nil
+}
+
+func (d *DefaultCompiler) resolveLocal(ctx context.Context, executableContext Context) (Context, error) {
+	resolved := executableContext
+	dir, err := filepath.EvalSymlinks(executableContext.Directory)
+	if err != nil {
+		return Context{}, err
+	}
+
+	if strings.HasSuffix(executableContext.MainPackage, ".go") {
+		// A file rather than a package, so refer to it relative to its module
+		file := executableContext.MainPackage
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		file, err = filepath.EvalSymlinks(file)
+		if err != nil {
+			return Context{}, err
+		}
+		root, err := executableContext.goCommand(ctx, filepath.Dir(file), "env", "GOMOD")
+		if err != nil {
+			return Context{}, err
+		}
+		resolved.Directory = moduleRoot(root, filepath.Dir(file))
+		rel, err := filepath.Rel(resolved.Directory, file)
+		if err != nil {
+			return Context{}, err
+		}
+		resolved.MainPackage = "./" + filepath.ToSlash(rel)
+		return resolved, nil
+	}
+
+	root, err := executableContext.goCommand(ctx, dir, "env", "GOMOD")
+	if err != nil {
+		return Context{}, err
+	}
+	resolved.Directory = moduleRoot(root, dir)
+	if !isModule(root) {
+		// Outside of a module, there is nothing to canonicalize relative to
+		return resolved, nil
+	}
+
+	args := []string{"list", "-e", "-f", "{{.ImportPath}}"}
+	args = append(args, executableContext.Flags.listArgs()...)
+	args = append(args, executableContext.MainPackage)
+	importPath, err := executableContext.goCommand(ctx, dir, args...)
+	if err != nil {
+		return Context{}, err
+	}
+	if strings.Contains(importPath, "\n") {
+		return Context{}, fmt.Errorf("%s matches more than one package", executableContext.MainPackage)
+	}
+	resolved.MainPackage = importPath
+	return resolved, nil
+}
+
+// isModule is true if the output of `go env GOMOD` means we are in a module
+func isModule(goMod string) bool {
+	return goMod != "" && goMod != os.DevNull
+}
+
+// moduleRoot is the directory containing goMod, or dir when not in a module
+func moduleRoot(goMod string, dir string) string {
+	if !isModule(goMod) {
+		return dir
+	}
+	return filepath.Dir(goMod)
+}
+
+// goCommand runs the go command in dir in this context's environment,
+// returning its trimmed output
+func (e Context) goCommand(ctx context.Context, dir string, args ...string) (string, error) {
+	return e.goCommandEnv(ctx, dir, e.environ(), args...)
+}
+
+func (e Context) goCommandEnv(ctx context.Context, dir string, env []string, args ...string) (string, error) {
+	cmd := e.goCmd(ctx, dir, env, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil && ctx.Err() != nil {
+		return "", context.Cause(ctx)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s %s failed: %w: %s", e.goBinary(), strings.Join(args, " "), err, stderr.String())
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// goCmd prepares the go command for this context. Cancelling ctx kills the
+// whole process group, since the go command runs the compiler, linker and
+// any VCS tools as children of its own.
+func (e Context) goCmd(ctx context.Context, dir string, env []string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, e.goBinary(), args...)
+	cmd.Dir = dir
+	cmd.Env = env
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = time.Second
+	return cmd
+}
@@ -0,0 +1,75 @@
+package build
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolve(t *testing.T) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	assert.NoError(t, err)
+	writeFiles(t, root, map[string]string{
+		"go.mod":              "module example.com/tool\n\ngo 1.22\n",
+		"cmd/tool/main.go":    "package main\n\nfunc main() {}\n",
+		"scripts/generate.go": "package main\n\nfunc main() {}\n",
+	})
+	link := filepath.Join(t.TempDir(), "link")
+	assert.NoError(t, os.Symlink(root, link))
+
+	cases := []struct {
+		description     string
+		context         Context
+		expectedContext Context
+	}{
+		{
+			description:     "package from the module root",
+			context:         Context{MainPackage: "./cmd/tool", Directory: root},
+			expectedContext: Context{MainPackage: "example.com/tool/cmd/tool", Directory: root},
+		},
+		{
+			description:     "package from its own directory",
+			context:         Context{MainPackage: ".", Directory: filepath.Join(root, "cmd/tool")},
+			expectedContext: Context{MainPackage: "example.com/tool/cmd/tool", Directory: root},
+		},
+		{
+			description:     "package through a symlink",
+			context:         Context{MainPackage: "./tool", Directory: filepath.Join(link, "cmd")},
+			expectedContext: Context{MainPackage: "example.com/tool/cmd/tool", Directory: root},
+		},
+		{
+			description:     "file from its own directory",
+			context:         Context{MainPackage: "generate.go", Directory: filepath.Join(root, "scripts")},
+			expectedContext: Context{MainPackage: "./scripts/generate.go", Directory: root},
+		},
+		{
+			description:     "file from another directory",
+			context:         Context{MainPackage: "../scripts/generate.go", Directory: filepath.Join(link, "cmd")},
+			expectedContext: Context{MainPackage: "./scripts/generate.go", Directory: root},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			resolved, err := (&DefaultCompiler{}).resolve(context.Background(), tc.context)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedContext.MainPackage, resolved.MainPackage)
+			assert.Equal(t, tc.expectedContext.Directory, resolved.Directory)
+			assert.NotEmpty(t, resolved.GoVersion)
+		})
+	}
+}
+
+func TestResolveOutsideModule(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	assert.NoError(t, err)
+	writeFiles(t, dir, map[string]string{
+		"main.go": "package main\n\nfunc main() {}\n",
+	})
+	resolved, err := (&DefaultCompiler{}).resolve(context.Background(), Context{MainPackage: "main.go", Directory: dir})
+	assert.NoError(t, err)
+	assert.Equal(t, "./main.go", resolved.MainPackage)
+	assert.Equal(t, dir, resolved.Directory)
+}
@@ -0,0 +1,148 @@
+package build
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// Priority orders builds that are waiting for a free build slot
+type Priority int
+
+const (
+	// Background builds are ones nobody is waiting on yet, such as rebuilds
+	// triggered by the watcher
+	Background Priority = iota
+	// Interactive builds have a gorun invocation waiting on them
+	Interactive
+)
+
+// scheduler limits how many builds run at once, handing out free slots to
+// the highest priority ticket, and first come first served within a priority
+type scheduler struct {
+	mu      sync.Mutex
+	max     int
+	running int
+	queue   []*ticket
+}
+
+// ticket is a request for a build slot
+type ticket struct {
+	scheduler *scheduler
+	priority  Priority
+	// ready is closed once the ticket holds a slot
+	ready chan struct{}
+	// moved is signalled when the ticket's position in the queue may have changed
+	moved chan struct{}
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{}
+}
+
+// setMax sets the number of builds that may run at once, zero meaning unlimited
+func (q *scheduler) setMax(max int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.max = max
+	q.dispatch()
+}
+
+// enqueue requests a slot, which is granted immediately if one is free
+func (q *scheduler) enqueue(priority Priority) *ticket {
+	t := &ticket{
+		scheduler: q,
+		priority:  priority,
+		ready:     make(chan struct{}),
+		moved:     make(chan struct{}, 1),
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.queue = append(q.queue, t)
+	q.sortQueue()
+	q.dispatch()
+	return t
+}
+
+// sortQueue orders the queue by priority, keeping arrival order within a
+// priority. Must be called with the lock held.
+func (q *scheduler) sortQueue() {
+	slices.SortStableFunc(q.queue, func(a, b *ticket) int {
+		return int(b.priority) - int(a.priority)
+	})
+}
+
+// dispatch hands out free slots and tells everyone still queued that they may
+// have moved. Must be called with the lock held.
+func (q *scheduler) dispatch() {
+	for len(q.queue) > 0 && (q.max <= 0 || q.running < q.max) {
+		t := q.queue[0]
+		q.queue = q.queue[1:]
+		q.running++
+		close(t.ready)
+	}
+	for _, t := range q.queue {
+		select {
+		case t.moved <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// position is the 1-based position of t in the queue, or 0 if it is not queued
+func (t *ticket) position() int {
+	q := t.scheduler
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return slices.Index(q.queue, t) + 1
+}
+
+// raise moves t ahead of lower priority tickets, if it is still queued
+func (t *ticket) raise(priority Priority) {
+	q := t.scheduler
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if priority <= t.priority {
+		return
+	}
+	t.priority = priority
+	q.sortQueue()
+	q.dispatch()
+}
+
+// wait blocks until t holds a slot, calling moved whenever its position in the
+// queue changes. If ctx is done first, t gives up its place in the queue.
+func (t *ticket) wait(ctx context.Context, moved func(position int)) error {
+	for {
+		select {
+		case <-t.ready:
+			return nil
+		case <-t.moved:
+			if position := t.position(); position > 0 {
+				moved(position)
+			}
+		case <-ctx.Done():
+			q := t.scheduler
+			q.mu.Lock()
+			defer q.mu.Unlock()
+			select {
+			case <-t.ready:
+				// Granted a slot at the last moment, so give it back
+				q.running--
+			default:
+				q.queue = slices.DeleteFunc(q.queue, func(other *ticket) bool { return other == t })
+			}
+			q.dispatch()
+			return context.Cause(ctx)
+		}
+	}
+}
+
+// release frees the slot held by t
+func (t *ticket) release() {
+	q := t.scheduler
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.running--
+	q.dispatch()
+}
@@ -0,0 +1,137 @@
+package build
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func granted(t *ticket) bool {
+	select {
+	case <-t.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestSchedulerLimitsRunningBuilds(t *testing.T) {
+	q := newScheduler()
+	q.setMax(2)
+
+	first := q.enqueue(Background)
+	second := q.enqueue(Background)
+	third := q.enqueue(Background)
+	assert.True(t, granted(first))
+	assert.True(t, granted(second))
+	assert.False(t, granted(third))
+	assert.Equal(t, 1, third.position())
+
+	first.release()
+	assert.True(t, granted(third))
+	assert.Equal(t, 0, third.position())
+}
+
+func TestSchedulerUnlimited(t *testing.T) {
+	q := newScheduler()
+	for range 10 {
+		assert.True(t, granted(q.enqueue(Background)))
+	}
+}
+
+func TestSchedulerPrefersInteractive(t *testing.T) {
+	q := newScheduler()
+	q.setMax(1)
+
+	running := q.enqueue(Background)
+	background := q.enqueue(Background)
+	interactive := q.enqueue(Interactive)
+	assert.Equal(t, 1, interactive.position())
+	assert.Equal(t, 2, background.position())
+
+	running.release()
+	assert.True(t, granted(interactive))
+	assert.False(t, granted(background))
+}
+
+func TestSchedulerRaise(t *testing.T) {
+	q := newScheduler()
+	q.setMax(1)
+
+	running := q.enqueue(Background)
+	waiting := q.enqueue(Interactive)
+	background := q.enqueue(Background)
+	background.raise(Interactive)
+	// Raising keeps arrival order among equals
+	assert.Equal(t, 1, waiting.position())
+	assert.Equal(t, 2, background.position())
+
+	running.release()
+	assert.True(t, granted(waiting))
+}
+
+func TestSchedulerWaitReportsPosition(t *testing.T) {
+	q := newScheduler()
+	q.setMax(1)
+
+	running := q.enqueue(Background)
+	ahead := q.enqueue(Background)
+	behind := q.enqueue(Background)
+
+	positions := make(chan int, 10)
+	done := make(chan error)
+	go func() {
+		done <- behind.wait(context.Background(), func(position int) { positions <- position })
+	}()
+	assert.Equal(t, 2, <-positions)
+
+	running.release()
+	assert.Equal(t, 1, <-positions)
+
+	ahead.release()
+	assert.NoError(t, <-done)
+}
+
+func TestSchedulerWaitCancelled(t *testing.T) {
+	q := newScheduler()
+	q.setMax(1)
+
+	running := q.enqueue(Background)
+	cancelled := q.enqueue(Background)
+	next := q.enqueue(Background)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, cancelled.wait(ctx, func(int) {}), context.Canceled)
+	assert.Equal(t, 1, next.position())
+
+	running.release()
+	assert.True(t, granted(next))
+}
+
+func TestCacheReportsQueuePosition(t *testing.T) {
+	compiler := newHangingCompiler()
+	cache := NewCache(t.TempDir(), compiler)
+	cache.SetMaxParallelBuilds(1)
+
+	runningCtx, cancelRunning := context.WithCancel(context.Background())
+	go func() {
+		_, _ = cache.GetExecutableFromContext(runningCtx, Context{MainPackage: "running"})
+	}()
+	<-compiler.started
+
+	events := make(chan Event, 10)
+	ctx, cancel := context.WithCancel(WithReporter(context.Background(), func(event Event) { events <- event }))
+	defer cancel()
+	go func() {
+		_, _ = cache.GetExecutableFromContext(ctx, Context{MainPackage: "queued"})
+	}()
+	assert.Equal(t, Event{Kind: EventQueued, QueuePosition: 1}, <-events)
+	assert.Never(t, func() bool { return len(compiler.started) > 0 }, 50*time.Millisecond, 10*time.Millisecond)
+
+	cancelRunning()
+	assert.Equal(t, Event{Kind: EventBuilding}, <-events)
+	<-compiler.started
+}
@@ -0,0 +1,97 @@
+package build
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+
+	log "github.com/lukemassa/clilog"
+)
+
+// objectsDir holds a hard link to every binary the cache builds, named by the
+// hash of its build inputs, so that contexts with identical inputs share one
+// binary, see inputHash
+const objectsDir = "objects"
+
+// inputHash identifies a build by everything that goes into it. Binaries built
+// without -trimpath record the paths of their sources, so the directory is
+// part of the hash unless TrimPath is set. Then, unlike Key, it does not
+// depend on the directory, so the same commit checked out in two places
+// builds to the same binary. The toolchain is identified by its version
+// rather than its path, the environment only by what changes the output, and
+// the platform is included since it decides what the client's environment
+// leaves unset, so that the hash can be shared with other machines.
+func (e Context) inputHash(fingerprint string) string {
+	dir := e.Directory
+	if e.Flags.TrimPath {
+		dir = ""
+	}
+	b := fmt.Appendf(nil, "%s\x00%s\x00%s\x00%s\x00%s\x00%s\x00%s/%s", fingerprint, e.MainPackage, dir,
+		strings.Join(e.Flags.Args(), "\x00"), strings.Join(e.outputEnv(), "\x00"), e.GoVersion,
+		runtime.GOOS, runtime.GOARCH)
+	return hashBytes(b)
+}
+
+// BuildID identifies the build of a binary in the cache. Binaries are named by
+// their input hash, so this is the same for every build from the same inputs.
+func BuildID(path string) string {
+	return filepath.Base(path)
+}
+
+func (s *Cache) objectPath(inputs string) string {
+	return filepath.Join(s.cacheDir, objectsDir, inputs)
+}
+
+// reuse finds an existing binary built from the same inputs as the context,
+// linking it into the context's directory if it was built for another one
+func (s *Cache) reuse(executableContext Context, src sources) (string, bool) {
+	if src.Fingerprint == "" {
+		return "", false
+	}
+	inputs := executableContext.inputHash(src.Fingerprint)
+	path := filepath.Join(s.cacheDir, executableContext.Key(), inputs)
+	if _, err := os.Stat(path); err != nil {
+		err = os.MkdirAll(filepath.Dir(path), 0700)
+		if err != nil {
+			return "", false
+		}
+		err = os.Link(s.objectPath(inputs), path)
+		if err != nil {
+			return "", false
+		}
+	}
+	touch(path)
+	return path, true
+}
+
+// store links the binary at path into the object store, replacing any older
+// build from the same inputs
+func (s *Cache) store(inputs, path string) {
+	err := os.MkdirAll(filepath.Join(s.cacheDir, objectsDir), 0700)
+	if err != nil {
+		log.Warnf("Failed to store %s: %v", path, err)
+		return
+	}
+	// Link under a temporary name first, since links cannot replace a file
+	tmp := s.objectPath(inputs) + ".tmp"
+	_ = os.Remove(tmp)
+	err = os.Link(path, tmp)
+	if err == nil {
+		err = os.Rename(tmp, s.objectPath(inputs))
+	}
+	if err != nil {
+		log.Warnf("Failed to store %s: %v", path, err)
+	}
+}
+
+// links is the number of hard links to a file
+func links(info os.FileInfo) uint64 {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 1
+	}
+	return uint64(stat.Nlink)
+}
@@ -0,0 +1,80 @@
+package build
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sameFile(t *testing.T, a, b string) bool {
+	t.Helper()
+	aInfo, err := os.Stat(a)
+	assert.NoError(t, err)
+	bInfo, err := os.Stat(b)
+	assert.NoError(t, err)
+	return os.SameFile(aInfo, bInfo)
+}
+
+func TestReuseAcrossDirectories(t *testing.T) {
+	compiler := newMockCompiler()
+	cache := NewCache(t.TempDir(), compiler)
+
+	trimmed := Flags{TrimPath: true}
+	first, err := cache.GetExecutableFromContext(context.Background(), Context{MainPackage: ".", Directory: "/worktrees/one", Flags: trimmed})
+	assert.NoError(t, err)
+	second, err := cache.GetExecutableFromContext(context.Background(), Context{MainPackage: ".", Directory: "/worktrees/two", Flags: trimmed})
+	assert.NoError(t, err)
+
+	assert.NotEqual(t, first, second)
+	assert.True(t, sameFile(t, first, second))
+	assert.Equal(t, 1, compiler.compiles)
+
+	// Anything else that goes into the build keeps them apart
+	third, err := cache.GetExecutableFromContext(context.Background(), Context{MainPackage: ".", Directory: "/worktrees/two", Flags: Flags{TrimPath: true, Race: true}})
+	assert.NoError(t, err)
+	assert.False(t, sameFile(t, first, third))
+	assert.Equal(t, 2, compiler.compiles)
+}
+
+func TestNoReuseAcrossDirectoriesWithoutTrimPath(t *testing.T) {
+	compiler := newMockCompiler()
+	cache := NewCache(t.TempDir(), compiler)
+
+	// Each binary records where its sources were
+	first, err := cache.GetExecutableFromContext(context.Background(), Context{MainPackage: ".", Directory: "/worktrees/one"})
+	assert.NoError(t, err)
+	second, err := cache.GetExecutableFromContext(context.Background(), Context{MainPackage: ".", Directory: "/worktrees/two"})
+	assert.NoError(t, err)
+
+	assert.False(t, sameFile(t, first, second))
+	assert.Equal(t, 2, compiler.compiles)
+}
+
+func TestReusePreviousSources(t *testing.T) {
+	compiler := newMockCompiler()
+	cache := NewCache(t.TempDir(), compiler)
+	c := Context{}
+
+	main, err := cache.GetExecutableFromContext(context.Background(), c)
+	assert.NoError(t, err)
+
+	compiler.setFingerprint("branch")
+	branch, err := cache.GetExecutableFromContext(context.Background(), c)
+	assert.NoError(t, err)
+	assert.NotEqual(t, main, branch)
+
+	// Switching back is a hit, whether asked for or noticed by the watcher
+	compiler.setFingerprint("initial")
+	path, err := cache.GetExecutableFromContext(context.Background(), c)
+	assert.NoError(t, err)
+	assert.Equal(t, main, path)
+	compiler.setFingerprint("branch")
+	assert.NoError(t, cache.Recompile(context.Background(), c, Background))
+	assert.Equal(t, 2, compiler.compiles)
+
+	// Unless the rebuild is forced
+	assert.NoError(t, cache.Recompile(context.Background(), c, Interactive))
+	assert.Equal(t, 3, compiler.compiles)
+}
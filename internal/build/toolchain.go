@@ -0,0 +1,24 @@
+package build
+
+import (
+	"context"
+	"os"
+)
+
+// goBinary is the go command to run for this context
+func (e Context) goBinary() string {
+	if e.Toolchain != "" {
+		return e.Toolchain
+	}
+	return "go"
+}
+
+// goVersion is the version of Go that builds for this context will use. It
+// accounts for the client's GOTOOLCHAIN and the module's go and toolchain
+// directives, since the go command applies those before running `go env`.
+func (e Context) goVersion(ctx context.Context) (string, error) {
+	if e.remote() {
+		return e.goCommandEnv(ctx, os.TempDir(), e.remoteEnviron(), "env", "GOVERSION")
+	}
+	return e.goCommand(ctx, e.Directory, "env", "GOVERSION")
+}
@@ -0,0 +1,62 @@
+package build
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeToolchain writes a go command that claims to be the given version, but
+// otherwise defers to the real go command
+func fakeToolchain(t *testing.T, version string) string {
+	t.Helper()
+	realGo, err := exec.LookPath("go")
+	assert.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "go")
+	script := `#!/bin/sh
+if [ "$1 $2" = "env GOVERSION" ]; then
+	echo ` + version + `
+	exit 0
+fi
+exec ` + realGo + ` "$@"
+`
+	assert.NoError(t, os.WriteFile(path, []byte(script), 0o755))
+	return path
+}
+
+func TestToolchainVersionChangesKey(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":  "module example.com/tool\n\ngo 1.22\n",
+		"main.go": "package main\n\nfunc main() {}\n",
+	})
+	compiler := &DefaultCompiler{}
+
+	defaultToolchain, err := compiler.resolve(context.Background(), Context{MainPackage: ".", Directory: dir})
+	assert.NoError(t, err)
+
+	oldToolchain := fakeToolchain(t, "go1.98.0")
+	old, err := compiler.resolve(context.Background(), Context{MainPackage: ".", Directory: dir, Toolchain: oldToolchain})
+	assert.NoError(t, err)
+	assert.Equal(t, "go1.98.0", old.GoVersion)
+
+	// Upgrading the toolchain in place must not reuse the old binaries
+	upgraded := fakeToolchain(t, "go1.99.0")
+	assert.NoError(t, os.Rename(upgraded, oldToolchain))
+	upgradedContext, err := compiler.resolve(context.Background(), Context{MainPackage: ".", Directory: dir, Toolchain: oldToolchain})
+	assert.NoError(t, err)
+	assert.Equal(t, "go1.99.0", upgradedContext.GoVersion)
+
+	assert.NotEqual(t, defaultToolchain.Key(), old.Key())
+	assert.NotEqual(t, old.Key(), upgradedContext.Key())
+}
+
+func TestExplicitToolchainDoesNotSwitch(t *testing.T) {
+	c := Context{Toolchain: "/opt/go/bin/go", Env: []string{"GOTOOLCHAIN=auto"}}
+	env := c.environ()
+	assert.Equal(t, "GOTOOLCHAIN=local", env[len(env)-1])
+}
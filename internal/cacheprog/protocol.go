@@ -0,0 +1,111 @@
+package cacheprog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Cmd is a request the go command makes, see cmd/go/internal/cacheprog
+type Cmd string
+
+const (
+	CmdGet   Cmd = "get"
+	CmdPut   Cmd = "put"
+	CmdClose Cmd = "close"
+)
+
+// Request is a message from the go command. A put with a BodySize is followed
+// by the body, as a base64 encoded JSON string.
+type Request struct {
+	ID       int64
+	Command  Cmd
+	ActionID []byte `json:",omitempty"`
+	OutputID []byte `json:",omitempty"`
+	BodySize int64  `json:",omitempty"`
+}
+
+// Response is a message to the go command, answering the Request with the
+// same ID. The first response, with ID 0, lists the commands we support.
+type Response struct {
+	ID            int64
+	Err           string     `json:",omitempty"`
+	KnownCommands []Cmd      `json:",omitempty"`
+	Miss          bool       `json:",omitempty"`
+	OutputID      []byte     `json:",omitempty"`
+	Size          int64      `json:",omitempty"`
+	Time          *time.Time `json:",omitempty"`
+	DiskPath      string     `json:",omitempty"`
+}
+
+// Serve answers the go command's requests from r on w until it asks us to
+// close, or r ends. Requests are answered concurrently, since answering a get
+// may mean a download.
+func Serve(ctx context.Context, store *Store, r io.Reader, w io.Writer) error {
+	var mu sync.Mutex
+	encoder := json.NewEncoder(w)
+	send := func(resp Response) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return encoder.Encode(resp)
+	}
+
+	err := send(Response{KnownCommands: []Cmd{CmdGet, CmdPut, CmdClose}})
+	if err != nil {
+		return err
+	}
+
+	var inFlight sync.WaitGroup
+	defer inFlight.Wait()
+	decoder := json.NewDecoder(bufio.NewReader(r))
+	for {
+		var req Request
+		err := decoder.Decode(&req)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading request: %w", err)
+		}
+
+		var body []byte
+		if req.Command == CmdPut && req.BodySize > 0 {
+			err := decoder.Decode(&body)
+			if err != nil {
+				return fmt.Errorf("reading body of request %d: %w", req.ID, err)
+			}
+		}
+
+		if req.Command == CmdClose {
+			inFlight.Wait()
+			return send(Response{ID: req.ID})
+		}
+
+		inFlight.Go(func() {
+			resp, err := handle(ctx, store, req, body)
+			resp.ID = req.ID
+			if err != nil {
+				resp.Err = err.Error()
+			}
+			// If the go command has gone, the next read fails too
+			_ = send(resp)
+		})
+	}
+}
+
+func handle(ctx context.Context, store *Store, req Request, body []byte) (Response, error) {
+	switch req.Command {
+	case CmdGet:
+		return store.get(ctx, req.ActionID)
+	case CmdPut:
+		path, err := store.put(req.ActionID, req.OutputID, bytes.NewReader(body), req.BodySize)
+		return Response{DiskPath: path}, err
+	}
+	return Response{}, fmt.Errorf("unknown command %q", req.Command)
+}
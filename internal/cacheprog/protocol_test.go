@@ -0,0 +1,107 @@
+package cacheprog
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// goCommand plays the part of the go command, talking to Serve
+type goCommand struct {
+	t         *testing.T
+	requests  io.WriteCloser
+	responses *json.Decoder
+	done      chan error
+	nextID    int64
+}
+
+func startServe(t *testing.T, store *Store) *goCommand {
+	t.Helper()
+	requestsReader, requests := io.Pipe()
+	responses, responsesWriter := io.Pipe()
+	g := &goCommand{
+		t:         t,
+		requests:  requests,
+		responses: json.NewDecoder(bufio.NewReader(responses)),
+		done:      make(chan error, 1),
+	}
+	go func() {
+		err := Serve(context.Background(), store, requestsReader, responsesWriter)
+		responsesWriter.Close()
+		g.done <- err
+	}()
+
+	var hello Response
+	assert.NoError(t, g.responses.Decode(&hello))
+	assert.Equal(t, []Cmd{CmdGet, CmdPut, CmdClose}, hello.KnownCommands)
+	return g
+}
+
+func (g *goCommand) do(req Request, body []byte) Response {
+	g.t.Helper()
+	g.nextID++
+	req.ID = g.nextID
+	content, err := json.Marshal(req)
+	assert.NoError(g.t, err)
+	_, err = fmt.Fprintf(g.requests, "%s\n", content)
+	assert.NoError(g.t, err)
+	if len(body) > 0 {
+		_, err = fmt.Fprintf(g.requests, "%q\n", base64.StdEncoding.EncodeToString(body))
+		assert.NoError(g.t, err)
+	}
+	var resp Response
+	assert.NoError(g.t, g.responses.Decode(&resp))
+	assert.Equal(g.t, req.ID, resp.ID)
+	return resp
+}
+
+func id(b byte) []byte {
+	id := make([]byte, 32)
+	id[0] = b
+	return id
+}
+
+func TestServe(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	assert.NoError(t, err)
+	g := startServe(t, store)
+
+	resp := g.do(Request{Command: CmdGet, ActionID: id(1)}, nil)
+	assert.True(t, resp.Miss)
+
+	body := []byte("compiled package")
+	resp = g.do(Request{Command: CmdPut, ActionID: id(1), OutputID: id(2), BodySize: int64(len(body))}, body)
+	assert.Empty(t, resp.Err)
+	content, err := os.ReadFile(resp.DiskPath)
+	assert.NoError(t, err)
+	assert.Equal(t, body, content)
+
+	resp = g.do(Request{Command: CmdGet, ActionID: id(1)}, nil)
+	assert.False(t, resp.Miss)
+	assert.Equal(t, id(2), resp.OutputID)
+	assert.Equal(t, int64(len(body)), resp.Size)
+	assert.NotNil(t, resp.Time)
+	content, err = os.ReadFile(resp.DiskPath)
+	assert.NoError(t, err)
+	assert.Equal(t, body, content)
+
+	// Empty outputs have no body
+	resp = g.do(Request{Command: CmdPut, ActionID: id(3), OutputID: id(4)}, nil)
+	assert.Empty(t, resp.Err)
+	resp = g.do(Request{Command: CmdGet, ActionID: id(3)}, nil)
+	assert.False(t, resp.Miss)
+	assert.Equal(t, int64(0), resp.Size)
+
+	resp = g.do(Request{Command: CmdGet}, nil)
+	assert.NotEmpty(t, resp.Err)
+
+	g.do(Request{Command: CmdClose}, nil)
+	assert.NoError(t, <-g.done)
+}
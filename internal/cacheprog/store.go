@@ -0,0 +1,416 @@
+// Package cacheprog implements a GOCACHEPROG program, so that the go command
+// keeps its build cache in gorun's working directory, shared with any remote
+// cache and bounded by gorund's garbage collection.
+package cacheprog
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/lukemassa/clilog"
+	"github.com/lukemassa/gorun/internal/build"
+)
+
+// How long an upload may take before it is abandoned. Build cache entries are
+// far smaller than the binaries the build package uploads.
+const uploadTimeout = time.Minute
+
+const statsFile = "stats.json"
+
+// Stats counts what the go command asked of the cache
+type Stats struct {
+	Gets       int64
+	Hits       int64
+	RemoteHits int64
+	Puts       int64
+	BytesPut   int64
+}
+
+func (s *Stats) add(other Stats) {
+	s.Gets += other.Gets
+	s.Hits += other.Hits
+	s.RemoteHits += other.RemoteHits
+	s.Puts += other.Puts
+	s.BytesPut += other.BytesPut
+}
+
+// actionEntry records the output of an action, see Store.put
+type actionEntry struct {
+	OutputID string
+	Size     int64
+	Time     time.Time
+}
+
+// Store keeps outputs in dir/outputs, and the output of each action in
+// dir/actions, each under a two character subdirectory to keep directories
+// small. Any number of processes may share a Store's directory.
+type Store struct {
+	dir     string
+	backend build.Backend
+
+	mu      sync.Mutex
+	stats   Stats
+	uploads sync.WaitGroup
+}
+
+// NewStore returns a store in dir, creating it if needed
+func NewStore(dir string) (*Store, error) {
+	for _, sub := range []string{"actions", "outputs"} {
+		err := os.MkdirAll(filepath.Join(dir, sub), 0o700)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &Store{dir: dir}, nil
+}
+
+// SetBackend shares the cache through b, looking there for anything missing
+// locally and uploading everything put
+func (s *Store) SetBackend(b build.Backend) {
+	s.backend = b
+}
+
+func (s *Store) path(kind, id string) string {
+	return filepath.Join(s.dir, kind, id[:2], id)
+}
+
+func (s *Store) count(f func(*Stats)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f(&s.stats)
+}
+
+func validID(id []byte) error {
+	if len(id) == 0 || len(id) > 64 {
+		return fmt.Errorf("invalid id of %d bytes", len(id))
+	}
+	return nil
+}
+
+// parseAction decodes an action entry, checking it refers to a valid output
+func parseAction(content []byte) (actionEntry, error) {
+	var entry actionEntry
+	err := json.Unmarshal(content, &entry)
+	if err != nil {
+		return actionEntry{}, err
+	}
+	id, err := hex.DecodeString(entry.OutputID)
+	if err == nil {
+		err = validID(id)
+	}
+	if err != nil {
+		return actionEntry{}, fmt.Errorf("invalid output id: %w", err)
+	}
+	return entry, nil
+}
+
+// get returns the response to a get request for the action
+func (s *Store) get(ctx context.Context, actionID []byte) (Response, error) {
+	if err := validID(actionID); err != nil {
+		return Response{}, err
+	}
+	s.count(func(st *Stats) { st.Gets++ })
+	action := hex.EncodeToString(actionID)
+
+	content, err := os.ReadFile(s.path("actions", action))
+	remote := false
+	if errors.Is(err, fs.ErrNotExist) && s.backend != nil {
+		content, err = s.download(ctx, action)
+		remote = true
+	}
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, build.ErrNotInBackend) {
+		return Response{Miss: true}, nil
+	}
+	if err != nil {
+		return Response{}, err
+	}
+	entry, err := parseAction(content)
+	if err != nil {
+		log.Warnf("Ignoring action %s: %v", action, err)
+		return Response{Miss: true}, nil
+	}
+
+	outputPath := s.path("outputs", entry.OutputID)
+	info, err := os.Stat(outputPath)
+	if err != nil || info.Size() != entry.Size {
+		// Evicted since the action was put
+		return Response{Miss: true}, nil
+	}
+	outputID, _ := hex.DecodeString(entry.OutputID)
+	now := time.Now()
+	_ = os.Chtimes(outputPath, now, now)
+	_ = os.Chtimes(s.path("actions", action), now, now)
+	s.count(func(st *Stats) {
+		st.Hits++
+		if remote {
+			st.RemoteHits++
+		}
+	})
+	return Response{
+		OutputID: outputID,
+		Size:     entry.Size,
+		Time:     &entry.Time,
+		DiskPath: outputPath,
+	}, nil
+}
+
+// download fetches an action and its output from the backend into the store,
+// returning the action entry
+func (s *Store) download(ctx context.Context, action string) ([]byte, error) {
+	tmp, err := os.MkdirTemp(s.dir, ".download-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	actionPath := filepath.Join(tmp, "action")
+	err = s.backend.Get(ctx, action, actionPath)
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(actionPath)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := parseAction(content)
+	if err != nil {
+		return nil, fmt.Errorf("downloaded action %s: %w", action, err)
+	}
+	outputPath := filepath.Join(tmp, "output")
+	err = s.backend.Get(ctx, entry.OutputID, outputPath)
+	if err != nil {
+		return nil, err
+	}
+	err = s.publish(s.path("outputs", entry.OutputID), outputPath)
+	if err != nil {
+		return nil, err
+	}
+	err = s.publish(s.path("actions", action), actionPath)
+	if err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// put stores the body as the output of the action, returning where it is on disk
+func (s *Store) put(actionID, outputID []byte, body io.Reader, size int64) (string, error) {
+	if err := validID(actionID); err != nil {
+		return "", err
+	}
+	if err := validID(outputID); err != nil {
+		return "", err
+	}
+	action := hex.EncodeToString(actionID)
+	output := hex.EncodeToString(outputID)
+
+	outputPath := s.path("outputs", output)
+	info, err := os.Stat(outputPath)
+	if err != nil || info.Size() != size {
+		err = s.write(outputPath, func(w io.Writer) error {
+			n, err := io.Copy(w, body)
+			if err == nil && n != size {
+				err = fmt.Errorf("body was %d bytes, expected %d", n, size)
+			}
+			return err
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	entry, err := json.Marshal(actionEntry{OutputID: output, Size: size, Time: time.Now()})
+	if err != nil {
+		return "", err
+	}
+	actionPath := s.path("actions", action)
+	err = s.write(actionPath, func(w io.Writer) error {
+		_, err := w.Write(entry)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	s.count(func(st *Stats) {
+		st.Puts++
+		st.BytesPut += size
+	})
+	s.upload(action, actionPath, output, outputPath)
+	return outputPath, nil
+}
+
+// write atomically creates the file at path with what fill writes
+func (s *Store) write(path string, fill func(io.Writer) error) error {
+	err := os.MkdirAll(filepath.Dir(path), 0o700)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	err = fill(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// publish moves the file at from into the store at path
+func (s *Store) publish(path, from string) error {
+	err := os.MkdirAll(filepath.Dir(path), 0o700)
+	if err != nil {
+		return err
+	}
+	return os.Rename(from, path)
+}
+
+// upload shares an action and its output with the backend in the background,
+// output first so that the action is never there without it
+func (s *Store) upload(action, actionPath, output, outputPath string) {
+	if s.backend == nil {
+		return
+	}
+	s.uploads.Go(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), uploadTimeout)
+		defer cancel()
+		err := s.backend.Put(ctx, output, outputPath, build.Metadata{})
+		if err == nil {
+			err = s.backend.Put(ctx, action, actionPath, build.Metadata{})
+		}
+		if err != nil {
+			log.Warnf("Failed to upload action %s: %v", action, err)
+		}
+	})
+}
+
+// Close waits for uploads to finish, and adds the stats of this process to
+// those of the store
+func (s *Store) Close() error {
+	s.uploads.Wait()
+	s.mu.Lock()
+	stats := s.stats
+	s.stats = Stats{}
+	s.mu.Unlock()
+	return s.updateStats(func(total *Stats) { total.add(stats) })
+}
+
+// updateStats changes the stats file, locking it against other processes
+func (s *Store) updateStats(update func(*Stats)) error {
+	f, err := os.OpenFile(filepath.Join(s.dir, statsFile), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+
+	var stats Stats
+	content, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	if len(content) > 0 {
+		err = json.Unmarshal(content, &stats)
+		if err != nil {
+			log.Warnf("Resetting unreadable cache stats: %v", err)
+			stats = Stats{}
+		}
+	}
+	update(&stats)
+	content, err = json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	err = f.Truncate(0)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteAt(content, 0)
+	return err
+}
+
+// ReadStats returns the combined stats of every process that has used the
+// store in dir
+func ReadStats(dir string) (Stats, error) {
+	var stats Stats
+	content, err := os.ReadFile(filepath.Join(dir, statsFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return stats, nil
+	}
+	if err != nil {
+		return stats, err
+	}
+	err = json.Unmarshal(content, &stats)
+	return stats, err
+}
+
+// files lists the actions and outputs on disk. Outputs are Shared, since
+// using an action uses its output.
+func (s *Store) files() ([]build.LRUFile, error) {
+	var files []build.LRUFile
+	for _, kind := range []string{"actions", "outputs"} {
+		err := filepath.WalkDir(filepath.Join(s.dir, kind), func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				// Removed since the directory was read
+				return nil
+			}
+			files = append(files, build.LRUFile{
+				Path:     path,
+				Size:     info.Size(),
+				LastUsed: info.ModTime(),
+				Shared:   kind == "outputs",
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// Collect removes outputs and actions until the store satisfies the policy,
+// see build.CollectLRU. MaxEntriesPerKey does not apply.
+func (s *Store) Collect(policy build.GCPolicy) (build.GCResult, error) {
+	files, err := s.files()
+	if err != nil {
+		return build.GCResult{}, err
+	}
+	result := build.CollectLRU(files, policy, func(f build.LRUFile) bool {
+		err := os.Remove(f.Path)
+		if err != nil && !os.IsNotExist(err) {
+			log.Warnf("Failed to remove %s: %v", f.Path, err)
+			return false
+		}
+		return true
+	})
+	log.Infof("Go build cache collection removed %d files, freeing %d bytes", result.Removed, result.Freed)
+	return result, nil
+}
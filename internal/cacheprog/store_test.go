@@ -0,0 +1,116 @@
+package cacheprog
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lukemassa/gorun/internal/build"
+	"github.com/stretchr/testify/assert"
+)
+
+func put(t *testing.T, store *Store, action, output byte, content string) string {
+	t.Helper()
+	path, err := store.put(id(action), id(output), strings.NewReader(content), int64(len(content)))
+	assert.NoError(t, err)
+	return path
+}
+
+func TestStats(t *testing.T) {
+	dir := t.TempDir()
+	for range 2 {
+		store, err := NewStore(dir)
+		assert.NoError(t, err)
+		put(t, store, 1, 2, "output")
+		_, err = store.get(context.Background(), id(1))
+		assert.NoError(t, err)
+		_, err = store.get(context.Background(), id(3))
+		assert.NoError(t, err)
+		assert.NoError(t, store.Close())
+	}
+
+	stats, err := ReadStats(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, Stats{Gets: 4, Hits: 2, Puts: 2, BytesPut: 12}, stats)
+}
+
+func TestCollect(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	assert.NoError(t, err)
+	old := put(t, store, 1, 2, "old output")
+	recent := put(t, store, 3, 4, "recent output")
+	longAgo := time.Now().Add(-time.Hour)
+	assert.NoError(t, os.Chtimes(old, longAgo, longAgo))
+	assert.NoError(t, os.Chtimes(store.path("actions", "01"+strings.Repeat("00", 31)), longAgo, longAgo))
+
+	result, err := store.Collect(build.GCPolicy{MaxAge: time.Minute})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Removed)
+	assert.NoFileExists(t, old)
+	assert.FileExists(t, recent)
+
+	resp, err := store.get(context.Background(), id(1))
+	assert.NoError(t, err)
+	assert.True(t, resp.Miss)
+
+	// Recently used outputs survive even when over the limit
+	_, err = store.Collect(build.GCPolicy{MaxBytes: 1})
+	assert.NoError(t, err)
+	assert.FileExists(t, recent)
+}
+
+// memoryBackend is a build.Backend in memory
+type memoryBackend struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func (m *memoryBackend) Get(ctx context.Context, name, path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	content, ok := m.files[name]
+	if !ok {
+		return build.ErrNotInBackend
+	}
+	return os.WriteFile(path, content, 0o600)
+}
+
+func (m *memoryBackend) Put(ctx context.Context, name, path string, metadata build.Metadata) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[name] = content
+	return nil
+}
+
+func TestBackend(t *testing.T) {
+	backend := &memoryBackend{files: make(map[string][]byte)}
+
+	ci, err := NewStore(t.TempDir())
+	assert.NoError(t, err)
+	ci.SetBackend(backend)
+	put(t, ci, 1, 2, "output")
+	assert.NoError(t, ci.Close())
+
+	developer, err := NewStore(t.TempDir())
+	assert.NoError(t, err)
+	developer.SetBackend(backend)
+	resp, err := developer.get(context.Background(), id(1))
+	assert.NoError(t, err)
+	assert.False(t, resp.Miss)
+	content, err := os.ReadFile(resp.DiskPath)
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal([]byte("output"), content))
+	assert.Equal(t, int64(1), developer.stats.RemoteHits)
+
+	resp, err = developer.get(context.Background(), id(3))
+	assert.NoError(t, err)
+	assert.True(t, resp.Miss)
+}
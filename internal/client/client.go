@@ -4,18 +4,22 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"time"
 
 	log "github.com/lukemassa/clilog"
+	"github.com/lukemassa/gorun/internal/build"
 	"github.com/lukemassa/gorun/internal/config"
 	"github.com/lukemassa/gorun/internal/server"
 )
 
 type Client struct {
 	httpClient *http.Client
+	sock       string
 }
 
 func NewClient(workingDir string) *Client {
@@ -31,80 +35,182 @@ func NewClient(workingDir string) *Client {
 		httpClient: &http.Client{
 			Transport: tr,
 		},
+		sock: sock,
 	}
 }
 
-func (c *Client) GetCommand(cmd string, env []string) (string, error) {
-
-	requestContent := server.ExecutableRequest{
-		MainPackage: cmd,
-		Env:         env,
-	}
-	b, err := json.Marshal(requestContent)
-	if err != nil {
-		return "", err
+// send sends the request to the server, with requestContent as the body
+// unless it is nil, returning the response on success. The caller must close
+// its body.
+func (c *Client) send(ctx context.Context, method, path string, requestContent any, accept string) (*http.Response, error) {
+	var body io.Reader
+	if requestContent != nil {
+		b, err := json.Marshal(requestContent)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(b)
 	}
 
 	// URL host is ignored — must be syntactically valid, but irrelevant.
-	req, err := http.NewRequest("POST", "http://unix/command", bytes.NewReader(b))
+	req, err := http.NewRequestWithContext(ctx, method, "http://unix"+path, body)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", accept)
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return "", err
-	}
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
+		return nil, err
 	}
 	if resp.StatusCode != 200 {
-		return "", fmt.Errorf("got %d calling API: %s", resp.StatusCode, string(body))
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("got %d calling API: %s", resp.StatusCode, string(body))
+	}
+	return resp, nil
+}
+
+// do sends the request to the server, returning the response body on success
+func (c *Client) do(method, path string, requestContent any) ([]byte, error) {
+	resp, err := c.send(context.Background(), method, path, requestContent, "application/json")
+	if err != nil {
+		return nil, err
 	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// GetCommand asks the server for the executable for the request. If progress
+// is not nil, it is passed each build event as the server reports it.
+func (c *Client) GetCommand(request server.ExecutableRequest, progress func(build.Event)) (server.ExecutableResponse, error) {
 	var commandResponse server.ExecutableResponse
-	err = json.Unmarshal(body, &commandResponse)
+	var err error
+	if progress == nil {
+		var body []byte
+		body, err = c.do("POST", "/command", request)
+		if err == nil {
+			err = json.Unmarshal(body, &commandResponse)
+		}
+	} else {
+		commandResponse, err = c.streamCommand(request, progress)
+	}
 	if err != nil {
-		return "", err
+		return server.ExecutableResponse{}, err
+	}
+	if commandResponse.QueuePosition > 0 {
+		log.Debugf("Waited in the build queue at position %d", commandResponse.QueuePosition)
 	}
 	if commandResponse.Executable == "" {
-		return "", fmt.Errorf("failed to compile: %s", commandResponse)
+		// The response may still hold a fallback
+		if !commandResponse.CompileFailed {
+			return commandResponse, errors.New(commandResponse.CompilationOutput)
+		}
+		return commandResponse, &build.CompileError{
+			Output:      commandResponse.CompilationOutput,
+			Diagnostics: commandResponse.Diagnostics,
+		}
 	}
 
-	return commandResponse.Executable, nil
+	return commandResponse, nil
 }
 
-func (c *Client) DeleteCommand(cmd string, env []string) error {
-
-	// TODO: Dedupe from above
-	requestContent := server.ExecutableRequest{
-		MainPackage: cmd,
-		Env:         env,
+// getJSON sends the request to the server, decoding the response into result
+func (c *Client) getJSON(method, path string, requestContent, result any) error {
+	body, err := c.do(method, path, requestContent)
+	if err != nil {
+		return err
 	}
-	b, err := json.Marshal(requestContent)
+	return json.Unmarshal(body, result)
+}
+
+// RebuildCommand builds the command again, even if it is up to date
+func (c *Client) RebuildCommand(request server.ExecutableRequest) error {
+	body, err := c.do("POST", "/command/rebuild", request)
 	if err != nil {
 		return err
 	}
+	log.Debugf("Rebuild response: %s", string(body))
+	return nil
+}
 
-	// URL host is ignored — must be syntactically valid, but irrelevant.
-	req, err := http.NewRequest("DELETE", "http://unix/command", bytes.NewReader(b))
+// EvictCommand removes the command from the cache, returning whether it was there
+func (c *Client) EvictCommand(request server.ExecutableRequest) (bool, error) {
+	var resp server.EvictResponse
+	err := c.getJSON("DELETE", "/command", request, &resp)
+	return resp.Evicted, err
+}
+
+// ListCommands returns the commands in the cache
+func (c *Client) ListCommands() ([]server.CommandInfo, error) {
+	var commands []server.CommandInfo
+	err := c.getJSON("GET", "/commands", nil, &commands)
+	return commands, err
+}
+
+// Clean removes every binary from the cache
+func (c *Client) Clean() (server.CleanResponse, error) {
+	var resp server.CleanResponse
+	err := c.getJSON("DELETE", "/commands", nil, &resp)
+	return resp, err
+}
+
+// Status describes the running daemon
+func (c *Client) Status() (server.StatusResponse, error) {
+	var resp server.StatusResponse
+	err := c.getJSON("GET", "/status", nil, &resp)
+	return resp, err
+}
+
+// How long reporting a run may hold up gorun's exit
+const reportTimeout = time.Second
+
+// ReportRun tells the server how a run of a command went
+func (c *Client) ReportRun(report server.RunReport) error {
+	ctx, cancel := context.WithTimeout(context.Background(), reportTimeout)
+	defer cancel()
+	resp, err := c.send(ctx, "POST", "/runs", report, "application/json")
 	if err != nil {
 		return err
 	}
-	req.Header.Set("Content-Type", "application/json")
+	return resp.Body.Close()
+}
 
-	resp, err := c.httpClient.Do(req)
+// RecordRun tells the server about a run without waiting for an answer, for
+// when gorun is about to exec the command. The request is written to the
+// socket before this returns, so the server reads it even once gorun is gone.
+func (c *Client) RecordRun(report server.RunReport) error {
+	body, err := json.Marshal(report)
 	if err != nil {
 		return err
 	}
-	body, err := io.ReadAll(resp.Body)
+	req, err := http.NewRequest("POST", "http://unix/runs", bytes.NewReader(body))
 	if err != nil {
 		return err
 	}
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("got %d calling API: %s", resp.StatusCode, string(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Close = true
+	conn, err := net.Dial("unix", c.sock)
+	if err != nil {
+		return err
 	}
-	log.Debugf("Deleted response: %s", string(body))
-	return nil
+	err = req.Write(conn)
+	if closeErr := conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// ListRuns returns the runs in the history that match the filter, the latest first
+func (c *Client) ListRuns(filter server.RunFilter) ([]server.RunReport, error) {
+	var runs []server.RunReport
+	path := "/runs"
+	if query := filter.Query().Encode(); query != "" {
+		path += "?" + query
+	}
+	err := c.getJSON("GET", path, nil, &runs)
+	return runs, err
 }
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/lukemassa/gorun/internal/build"
+	"github.com/lukemassa/gorun/internal/server"
+)
+
+// streamCommand asks the server for the executable as a stream of events,
+// passing progress to progress until the result arrives
+func (c *Client) streamCommand(request server.ExecutableRequest, progress func(build.Event)) (server.ExecutableResponse, error) {
+	resp, err := c.send(context.Background(), "POST", "/command", request, server.EventStreamType)
+	if err != nil {
+		return server.ExecutableResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	var result server.ExecutableResponse
+	found := false
+	err = readEvents(resp.Body, func(name string, data []byte) (bool, error) {
+		switch name {
+		case server.EventProgress:
+			var event build.Event
+			err := json.Unmarshal(data, &event)
+			if err != nil {
+				return false, err
+			}
+			progress(event)
+			return true, nil
+		case server.EventDone, server.EventFailed:
+			found = true
+			return false, json.Unmarshal(data, &result)
+		}
+		// Ignore events we don't know about
+		return true, nil
+	})
+	if err == nil && !found {
+		err = errors.New("server closed the stream without a result")
+	}
+	return result, err
+}
+
+// readEvents parses server-sent events from r, passing each to f until f
+// returns false or an error, or r ends
+func readEvents(r io.Reader, f func(name string, data []byte) (bool, error)) error {
+	reader := bufio.NewReader(r)
+	var name string
+	var data []string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("reading events: %w", err)
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		if line == "" {
+			if len(data) > 0 {
+				more, err := f(name, []byte(strings.Join(data, "\n")))
+				if err != nil || !more {
+					return err
+				}
+			}
+			name, data = "", nil
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			name = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package client
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadEvents(t *testing.T) {
+	stream := "event: progress\ndata: one\n\n" +
+		": a comment\r\nevent: progress\r\ndata: two\r\ndata: lines\r\n\r\n" +
+		"event: done\ndata:three\n\n" +
+		"event: progress\ndata: unread\n\n"
+
+	var got []string
+	err := readEvents(strings.NewReader(stream), func(name string, data []byte) (bool, error) {
+		got = append(got, name+"="+string(data))
+		return name != "done", nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"progress=one", "progress=two\nlines", "done=three"}, got)
+}
@@ -1,8 +1,12 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // TODO: Don't panic
@@ -22,3 +26,141 @@ func WorkingDir() string {
 func Sock(workingDir string) string {
 	return filepath.Join(workingDir, "gorun.sock")
 }
+
+// GoCacheDir is where `gorund cacheprog` keeps the go command's build cache
+func GoCacheDir(workingDir string) string {
+	return filepath.Join(workingDir, "gocache")
+}
+
+// HistoryFile is where gorund keeps the history of runs
+func HistoryFile(workingDir string) string {
+	return filepath.Join(workingDir, "history.jsonl")
+}
+
+// Daemon holds the settings for gorund. The zero value disables every
+// optional behavior, which is what tests generally want.
+type Daemon struct {
+	// How long a build may take before it is abandoned, or zero for no limit
+	BuildTimeout time.Duration
+	// How many builds may run at once, or zero for no limit
+	MaxParallelBuilds int
+	// URL of a remote cache to share binaries through, such as gorun-cache
+	RemoteCache string
+	// Whether builds use `gorund cacheprog` as their GOCACHEPROG
+	GoCacheProg bool
+	// Limit on the size of the go command's build cache in GoCacheDir, or zero for no limit
+	GoCacheMaxBytes int64
+	// How often to garbage collect the cache, or zero to never do so
+	GCInterval time.Duration
+	// Limits on the cache, see build.GCPolicy
+	CacheMaxBytes         int64
+	CacheMaxEntriesPerKey int
+	CacheMaxAge           time.Duration
+	// How many runs to keep in the history, or zero to keep none
+	HistorySize int
+}
+
+// DaemonFromEnv reads the daemon settings from GORUN_* environment variables,
+// falling back to defaults for anything not set
+func DaemonFromEnv() (Daemon, error) {
+	d := Daemon{
+		BuildTimeout:          10 * time.Minute,
+		MaxParallelBuilds:     2,
+		GCInterval:            10 * time.Minute,
+		CacheMaxBytes:         2 << 30,
+		CacheMaxEntriesPerKey: 3,
+		CacheMaxAge:           30 * 24 * time.Hour,
+		GoCacheMaxBytes:       10 << 30,
+		HistorySize:           1000,
+	}
+	var err error
+	if d.BuildTimeout, err = durationFromEnv("GORUN_BUILD_TIMEOUT", d.BuildTimeout); err != nil {
+		return Daemon{}, err
+	}
+	if d.MaxParallelBuilds, err = intFromEnv("GORUN_MAX_PARALLEL_BUILDS", d.MaxParallelBuilds); err != nil {
+		return Daemon{}, err
+	}
+	d.RemoteCache = os.Getenv("GORUN_REMOTE_CACHE")
+	if d.GCInterval, err = durationFromEnv("GORUN_GC_INTERVAL", d.GCInterval); err != nil {
+		return Daemon{}, err
+	}
+	if d.CacheMaxBytes, err = bytesFromEnv("GORUN_CACHE_MAX_BYTES", d.CacheMaxBytes); err != nil {
+		return Daemon{}, err
+	}
+	if d.CacheMaxEntriesPerKey, err = intFromEnv("GORUN_CACHE_MAX_ENTRIES", d.CacheMaxEntriesPerKey); err != nil {
+		return Daemon{}, err
+	}
+	if d.CacheMaxAge, err = durationFromEnv("GORUN_CACHE_MAX_AGE", d.CacheMaxAge); err != nil {
+		return Daemon{}, err
+	}
+	if d.GoCacheProg, err = boolFromEnv("GORUN_GOCACHEPROG", d.GoCacheProg); err != nil {
+		return Daemon{}, err
+	}
+	if d.GoCacheMaxBytes, err = bytesFromEnv("GORUN_GOCACHE_MAX_BYTES", d.GoCacheMaxBytes); err != nil {
+		return Daemon{}, err
+	}
+	if d.HistorySize, err = intFromEnv("GORUN_HISTORY_SIZE", d.HistorySize); err != nil {
+		return Daemon{}, err
+	}
+	if d.HistorySize < 0 {
+		return Daemon{}, fmt.Errorf("invalid GORUN_HISTORY_SIZE: %d is negative", d.HistorySize)
+	}
+	return d, nil
+}
+
+func boolFromEnv(name string, fallback bool) (bool, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return b, nil
+}
+
+func durationFromEnv(name string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return d, nil
+}
+
+func intFromEnv(name string, fallback int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return i, nil
+}
+
+// bytesFromEnv parses a size such as 1048576, 512M or 2G
+func bytesFromEnv(name string, fallback int64) (int64, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	multiplier := int64(1)
+	for suffix, m := range map[string]int64{"K": 1 << 10, "M": 1 << 20, "G": 1 << 30} {
+		if trimmed, ok := strings.CutSuffix(strings.ToUpper(value), suffix); ok {
+			value = trimmed
+			multiplier = m
+			break
+		}
+	}
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return i * multiplier, nil
+}
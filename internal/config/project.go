@@ -0,0 +1,49 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ProjectFile is the file in a module's root directory that holds gorun's
+// settings for the module, such as {"Stale": true}
+const ProjectFile = ".gorun.json"
+
+// Project holds gorun's settings for a module, which flags and GORUN_*
+// environment variables override
+type Project struct {
+	// Stale runs the previous build straight away while rebuilding, see gorun -stale
+	Stale bool
+}
+
+// ProjectFromDir reads the settings of the module containing dir, looking in
+// dir and each parent up to the module root. The zero value means there are none.
+func ProjectFromDir(dir string) (Project, error) {
+	for {
+		path := filepath.Join(dir, ProjectFile)
+		content, err := os.ReadFile(path)
+		if err == nil {
+			var p Project
+			err = json.Unmarshal(content, &p)
+			if err != nil {
+				return Project{}, fmt.Errorf("invalid %s: %w", path, err)
+			}
+			return p, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return Project{}, err
+		}
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return Project{}, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return Project{}, nil
+		}
+		dir = parent
+	}
+}
@@ -0,0 +1,63 @@
+package remote
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	log "github.com/lukemassa/clilog"
+)
+
+// validName matches the names Backend stores: a hash, or its metadata
+var validName = regexp.MustCompile(`^([0-9a-f]{32}|[0-9a-f]{64})(\.json)?$`)
+
+// Handler serves binaries stored in dir to Backend. It is a reference
+// implementation for trying out remote caching, with no authentication or
+// eviction; anything that serves files over GET and PUT in the same way works.
+func Handler(dir string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /binaries/{name}", func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		if !validName.MatchString(name) {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, filepath.Join(dir, name))
+	})
+	mux.HandleFunc("PUT /binaries/{name}", func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		if !validName.MatchString(name) {
+			http.Error(w, fmt.Sprintf("invalid name %q", name), http.StatusBadRequest)
+			return
+		}
+		err := writeFile(filepath.Join(dir, name), r.Body)
+		if err != nil {
+			log.Warnf("Failed to store %s: %v", name, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Infof("Stored %s", name)
+		w.WriteHeader(http.StatusCreated)
+	})
+	return mux
+}
+
+// writeFile atomically replaces the file at path with the contents of r
+func writeFile(path string, r io.Reader) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,159 @@
+// Package remote shares binaries between gorun daemons over HTTP.
+//
+// A binary built from inputs with hash H is stored at /binaries/H, and its
+// metadata at /binaries/H.json. The metadata is written last and includes a
+// checksum of the binary, so a binary without metadata is not used.
+package remote
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/lukemassa/gorun/internal/build"
+)
+
+// record is the metadata stored alongside each binary
+type record struct {
+	build.Metadata
+	Size   int64
+	SHA256 string
+}
+
+// Backend is a build.Backend that talks to a server such as Handler
+type Backend struct {
+	baseURL string
+	client  *http.Client
+}
+
+var _ build.Backend = (*Backend)(nil)
+
+// NewBackend returns a backend for the server at baseURL
+func NewBackend(baseURL string) (*Backend, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported remote cache URL %q, expected http or https", baseURL)
+	}
+	return &Backend{
+		baseURL: u.String(),
+		client:  http.DefaultClient,
+	}, nil
+}
+
+func (b *Backend) url(name string) string {
+	return b.baseURL + "/binaries/" + name
+}
+
+func (b *Backend) Get(ctx context.Context, inputs, path string) error {
+	var rec record
+	err := b.getJSON(ctx, inputs+".json", &rec)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", b.url(inputs), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", req.URL, resp.Status)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, h), resp.Body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if size != rec.Size || hex.EncodeToString(h.Sum(nil)) != rec.SHA256 {
+		return fmt.Errorf("downloaded binary for %s does not match its metadata", inputs)
+	}
+	return nil
+}
+
+func (b *Backend) getJSON(ctx context.Context, name string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", b.url(name), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return build.ErrNotInBackend
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", req.URL, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (b *Backend) Put(ctx context.Context, inputs, path string, metadata build.Metadata) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	err = b.put(ctx, inputs, f, size)
+	if err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(record{
+		Metadata: metadata,
+		Size:     size,
+		SHA256:   hex.EncodeToString(h.Sum(nil)),
+	})
+	if err != nil {
+		return err
+	}
+	return b.put(ctx, inputs+".json", bytes.NewReader(content), int64(len(content)))
+}
+
+func (b *Backend) put(ctx context.Context, name string, body io.Reader, size int64) error {
+	req, err := http.NewRequestWithContext(ctx, "PUT", b.url(name), body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("PUT %s: %s", req.URL, resp.Status)
+	}
+	return nil
+}
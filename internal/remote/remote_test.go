@@ -0,0 +1,72 @@
+package remote
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lukemassa/gorun/internal/build"
+	"github.com/stretchr/testify/assert"
+)
+
+const inputs = "0123456789abcdef0123456789abcdef"
+
+func newTestBackend(t *testing.T) (*Backend, string) {
+	t.Helper()
+	dir := t.TempDir()
+	server := httptest.NewServer(Handler(dir))
+	t.Cleanup(server.Close)
+	backend, err := NewBackend(server.URL)
+	assert.NoError(t, err)
+	return backend, dir
+}
+
+func TestRoundTrip(t *testing.T) {
+	backend, _ := newTestBackend(t)
+	local := t.TempDir()
+	binary := filepath.Join(local, "binary")
+	assert.NoError(t, os.WriteFile(binary, []byte("binary contents"), 0o700))
+
+	err := backend.Get(context.Background(), inputs, filepath.Join(local, "missing"))
+	assert.ErrorIs(t, err, build.ErrNotInBackend)
+
+	assert.NoError(t, backend.Put(context.Background(), inputs, binary, build.Metadata{MainPackage: "example.com/tool"}))
+
+	downloaded := filepath.Join(local, "downloaded")
+	assert.NoError(t, backend.Get(context.Background(), inputs, downloaded))
+	content, err := os.ReadFile(downloaded)
+	assert.NoError(t, err)
+	assert.Equal(t, "binary contents", string(content))
+}
+
+func TestCorruptBinary(t *testing.T) {
+	backend, dir := newTestBackend(t)
+	binary := filepath.Join(t.TempDir(), "binary")
+	assert.NoError(t, os.WriteFile(binary, []byte("binary contents"), 0o700))
+	assert.NoError(t, backend.Put(context.Background(), inputs, binary, build.Metadata{}))
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, inputs), []byte("something else"), 0o600))
+	err := backend.Get(context.Background(), inputs, filepath.Join(t.TempDir(), "downloaded"))
+	assert.ErrorContains(t, err, "does not match")
+}
+
+func TestHandlerRejectsOtherNames(t *testing.T) {
+	server := httptest.NewServer(Handler(t.TempDir()))
+	defer server.Close()
+
+	req, err := http.NewRequest("PUT", server.URL+"/binaries/..%2Fescape", strings.NewReader("x"))
+	assert.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestNewBackendRejectsOtherSchemes(t *testing.T) {
+	_, err := NewBackend("file:///tmp/cache")
+	assert.Error(t, err)
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	log "github.com/lukemassa/clilog"
+	"github.com/lukemassa/gorun/internal/build"
+)
+
+// EvictResponse is the result of DELETE /command
+type EvictResponse struct {
+	// Evicted is false if the command was not in the cache
+	Evicted bool
+}
+
+// CommandInfo describes a cached command, see GET /commands
+type CommandInfo struct {
+	MainPackage string
+	Directory   string
+	Flags       build.Flags
+	Toolchain   string `json:",omitempty"`
+	// Executable is empty if the command is building for the first time
+	Executable    string
+	Size          int64
+	BuiltAt       time.Time
+	BuildDuration time.Duration
+	Hits          int
+	LastUsed      time.Time
+	// Stale is true if the sources changed since Executable was built
+	Stale bool
+	// Failed is true if the latest build failed, so Executable is older
+	Failed   bool
+	Building bool
+}
+
+// CleanResponse is the result of DELETE /commands
+type CleanResponse struct {
+	Removed int
+	Freed   int64
+}
+
+// StatusResponse is the result of GET /status
+type StatusResponse struct {
+	Pid        int
+	WorkingDir string
+	StartedAt  time.Time
+	// Commands is the number of cached commands, of which Building are being built
+	Commands int
+	Building int
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	content, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(500)
+		fmt.Fprintf(w, "Failed to json marshal result: %v", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(content)
+}
+
+func (s *Server) handleEvict(w http.ResponseWriter, r *http.Request) {
+	var req ExecutableRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, "Failed to parse json: %v", err)
+		return
+	}
+
+	log.Infof("Requested eviction of %s", req.MainPackage)
+	executableContext, err := s.cache.Resolve(r.Context(), req.context())
+	if err != nil {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, "Failed to resolve %s: %v", req.MainPackage, err)
+		return
+	}
+	evicted, err := s.cache.Evict(executableContext)
+	if errors.Is(err, build.ErrBuilding) {
+		w.WriteHeader(409)
+		fmt.Fprintf(w, "Failed to evict %s: %v", req.MainPackage, err)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(500)
+		fmt.Fprintf(w, "Failed to evict %s: %v", req.MainPackage, err)
+		return
+	}
+	writeJSON(w, EvictResponse{Evicted: evicted})
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	commands := []CommandInfo{}
+	for _, entry := range s.cache.List(r.Context()) {
+		commands = append(commands, CommandInfo{
+			MainPackage:   entry.Context.MainPackage,
+			Directory:     entry.Context.Directory,
+			Flags:         entry.Context.Flags,
+			Toolchain:     entry.Context.Toolchain,
+			Executable:    entry.Path,
+			Size:          entry.Size,
+			BuiltAt:       entry.BuiltAt,
+			BuildDuration: entry.BuildDuration,
+			Hits:          entry.Hits,
+			LastUsed:      entry.LastUsed,
+			Stale:         entry.Stale,
+			Failed:        entry.Failed,
+			Building:      entry.Building,
+		})
+	}
+	writeJSON(w, commands)
+}
+
+func (s *Server) handleClean(w http.ResponseWriter, r *http.Request) {
+	log.Info("Requested cleaning of the cache")
+	result, err := s.cache.Clean()
+	if err != nil {
+		w.WriteHeader(500)
+		fmt.Fprintf(w, "Failed to clean the cache: %v", err)
+		return
+	}
+	writeJSON(w, CleanResponse{Removed: result.Removed, Freed: result.Freed})
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	status := StatusResponse{
+		Pid:        os.Getpid(),
+		WorkingDir: s.workingDir,
+		StartedAt:  s.startedAt,
+	}
+	status.Commands, status.Building = s.cache.Count()
+	writeJSON(w, status)
+}
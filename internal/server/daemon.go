@@ -15,13 +15,22 @@ import (
 	log "github.com/lukemassa/clilog"
 )
 
+// readyFDEnv names the file descriptor on which a daemon started by
+// OSProcessController says it is serving, see notifyReady
+const readyFDEnv = "GORUN_READY_FD"
+
+// How long Daemon.Start waits for the daemon to be serving
+const readyTimeout = 10 * time.Second
+
 type Daemon struct {
 	server            *Server
 	processController ProcessController
 }
 
 type ProcessController interface {
-	Start(logFile io.Writer) (pid int, err error)
+	// Start starts the daemon, returning a channel that receives nil once it
+	// is serving, or an error if it exits first
+	Start(logFile io.Writer) (pid int, ready <-chan error, err error)
 	Stop(pid int) error
 	Alive(pid int) bool
 }
@@ -38,15 +47,40 @@ func NewOSProcessController(cmd string, args ...string) OSProcessController {
 	}
 }
 
-func (o OSProcessController) Start(log io.Writer) (int, error) {
+// Start runs the command in its own session, so that it outlives whoever
+// started it, passing it the write end of a pipe on which to say it is ready
+func (o OSProcessController) Start(log io.Writer) (int, <-chan error, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 0, nil, err
+	}
 	cmd := exec.Command(o.cmd, o.args...)
 	cmd.Stdout = log
 	cmd.Stderr = log
-	err := cmd.Start()
+	// ExtraFiles start at file descriptor 3
+	cmd.ExtraFiles = []*os.File{w}
+	cmd.Env = append(os.Environ(), readyFDEnv+"=3")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	err = cmd.Start()
+	// Only the daemon may hold the write end, so that reading sees the end of
+	// the pipe if it exits without saying it is ready
+	w.Close()
 	if err != nil {
-		return 0, err
+		r.Close()
+		return 0, nil, err
 	}
-	return cmd.Process.Pid, nil
+
+	ready := make(chan error, 1)
+	go func() {
+		defer r.Close()
+		n, _ := r.Read(make([]byte, 1))
+		if n == 0 {
+			ready <- errors.New("daemon exited before it was ready")
+			return
+		}
+		ready <- nil
+	}()
+	return cmd.Process.Pid, ready, nil
 }
 
 func (o OSProcessController) Stop(pid int) error {
@@ -144,7 +178,7 @@ func (d *Daemon) Start() error {
 	}
 	defer gorunLog.Close()
 
-	pid, err = d.processController.Start(gorunLog)
+	pid, ready, err := d.processController.Start(gorunLog)
 	if err != nil {
 		return err
 	}
@@ -152,6 +186,14 @@ func (d *Daemon) Start() error {
 	if err != nil {
 		return err
 	}
+	select {
+	case err = <-ready:
+	case <-time.After(readyTimeout):
+		err = fmt.Errorf("daemon %d not ready after %s", pid, readyTimeout)
+	}
+	if err != nil {
+		return fmt.Errorf("%w, see %s", err, d.logFile())
+	}
 	log.Infof("Started process %d", pid)
 	return nil
 }
@@ -176,3 +218,25 @@ func (d *Daemon) Stop() error {
 	log.Infof("Stopped %d", pid)
 	return nil
 }
+
+// notifyReady tells whoever started the daemon, if it was OSProcessController,
+// that it is serving
+func notifyReady() {
+	fd := os.Getenv(readyFDEnv)
+	if fd == "" {
+		return
+	}
+	// Nothing the daemon runs should inherit it
+	os.Unsetenv(readyFDEnv)
+	n, err := strconv.Atoi(fd)
+	if err != nil {
+		log.Warnf("Invalid %s %q", readyFDEnv, fd)
+		return
+	}
+	f := os.NewFile(uintptr(n), "ready")
+	defer f.Close()
+	_, err = f.Write([]byte{'\n'})
+	if err != nil {
+		log.Warnf("Failed to say the daemon is ready: %v", err)
+	}
+}
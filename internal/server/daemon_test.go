@@ -10,6 +10,7 @@ import (
 	"time"
 
 	log "github.com/lukemassa/clilog"
+	"github.com/lukemassa/gorun/internal/config"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -17,9 +18,11 @@ type mockRunner struct {
 	isStarted bool
 }
 
-func (m *mockRunner) Start(_ io.Writer) (int, error) {
+func (m *mockRunner) Start(_ io.Writer) (int, <-chan error, error) {
 	m.isStarted = true
-	return 1234, nil
+	ready := make(chan error, 1)
+	ready <- nil
+	return 1234, ready, nil
 }
 
 func (m *mockRunner) Alive(pid int) bool {
@@ -36,7 +39,7 @@ func TestDaemon(t *testing.T) {
 
 	runner := &mockRunner{}
 	dir := t.TempDir()
-	s := NewServer(dir)
+	s := NewServer(dir, config.Daemon{})
 	d := NewDaemon(s, runner)
 
 	log.Info("Deamon is configured")
@@ -80,8 +83,8 @@ func waitForPid(t *testing.T, pid int) {
 
 func TestOSProcessController(t *testing.T) {
 
-	// Setup a process that writes to stdout then sleeps
-	p := NewOSProcessController("sh", "-c", "echo hello && sleep 10")
+	// Setup a process that writes to stdout, says it is ready, then sleeps
+	p := NewOSProcessController("sh", "-c", "echo hello && echo >&$GORUN_READY_FD && sleep 10")
 	dir := t.TempDir()
 	logPath := filepath.Join(dir, "out.log")
 	f, err := os.Create(logPath)
@@ -89,8 +92,9 @@ func TestOSProcessController(t *testing.T) {
 	defer f.Close()
 
 	// Start the process
-	pid, err := p.Start(f)
+	pid, ready, err := p.Start(f)
 	assert.NoError(t, err)
+	assert.NoError(t, <-ready)
 
 	// Wait until the contents are written to the file
 	assert.Eventually(t, func() bool {
@@ -116,3 +120,11 @@ func TestOSProcessController(t *testing.T) {
 	assert.False(t, p.Alive(pid))
 
 }
+
+func TestOSProcessControllerNotReady(t *testing.T) {
+	p := NewOSProcessController("sh", "-c", "exit 1")
+	pid, ready, err := p.Start(io.Discard)
+	assert.NoError(t, err)
+	waitForPid(t, pid)
+	assert.ErrorContains(t, <-ready, "exited before it was ready")
+}
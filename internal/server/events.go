@@ -0,0 +1,53 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Names of the server-sent events a POST /command streams, when the client
+// accepts text/event-stream. Any number of progress events, each a
+// build.Event, are followed by exactly one done or failed event holding the
+// ExecutableResponse.
+const (
+	EventProgress = "progress"
+	EventDone     = "done"
+	EventFailed   = "failed"
+)
+
+// EventStreamType is the content type of a stream of server-sent events
+const EventStreamType = "text/event-stream"
+
+func acceptsEvents(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), EventStreamType)
+}
+
+// eventStream writes server-sent events, flushing each so that the client
+// sees it straight away
+type eventStream struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+func newEventStream(w http.ResponseWriter) *eventStream {
+	w.Header().Set("Content-Type", EventStreamType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(200)
+	s := &eventStream{w: w, rc: http.NewResponseController(w)}
+	_ = s.rc.Flush()
+	return s
+}
+
+func (s *eventStream) send(name string, v any) error {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", name, content)
+	if err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
@@ -0,0 +1,26 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lukemassa/gorun/internal/build"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventStream(t *testing.T) {
+	w := httptest.NewRecorder()
+	events := newEventStream(w)
+	assert.NoError(t, events.send(EventProgress, build.Event{Kind: build.EventQueued, QueuePosition: 2}))
+	assert.NoError(t, events.send(EventDone, ExecutableResponse{Executable: "/bin/true"}))
+
+	assert.Equal(t, EventStreamType, w.Header().Get("Content-Type"))
+	assert.True(t, w.Flushed)
+	assert.Equal(t, `event: progress
+data: {"Kind":"queued","QueuePosition":2}
+
+event: done
+data: {"Executable":"/bin/true","CompilationOutput":"","Diagnostics":null}
+
+`, w.Body.String())
+}
@@ -0,0 +1,252 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/lukemassa/clilog"
+)
+
+// Run statuses to filter the history by, see RunFilter
+const (
+	RunSucceeded = "ok"
+	RunFailed    = "failed"
+)
+
+// RunFilter picks runs from the history, see GET /runs. Zero values match
+// every run.
+type RunFilter struct {
+	// MainPackage matches runs whose package contains it
+	MainPackage string
+	// Directory matches runs in it or below it, or in a module containing it
+	Directory string
+	// Since matches runs started after it
+	Since time.Time
+	// Status matches runs that exited with RunSucceeded or RunFailed
+	Status string
+	// Limit is the most runs to return, the latest first
+	Limit int
+}
+
+// Query encodes the filter as URL query parameters
+func (f RunFilter) Query() url.Values {
+	v := url.Values{}
+	if f.MainPackage != "" {
+		v.Set("package", f.MainPackage)
+	}
+	if f.Directory != "" {
+		v.Set("dir", f.Directory)
+	}
+	if !f.Since.IsZero() {
+		v.Set("since", f.Since.Format(time.RFC3339Nano))
+	}
+	if f.Status != "" {
+		v.Set("status", f.Status)
+	}
+	if f.Limit > 0 {
+		v.Set("limit", strconv.Itoa(f.Limit))
+	}
+	return v
+}
+
+func parseRunFilter(v url.Values) (RunFilter, error) {
+	f := RunFilter{
+		MainPackage: v.Get("package"),
+		Directory:   v.Get("dir"),
+		Status:      v.Get("status"),
+	}
+	switch f.Status {
+	case "", RunSucceeded, RunFailed:
+	default:
+		return f, fmt.Errorf("invalid status %q", f.Status)
+	}
+	var err error
+	if since := v.Get("since"); since != "" {
+		f.Since, err = time.Parse(time.RFC3339Nano, since)
+		if err != nil {
+			return f, fmt.Errorf("invalid since: %w", err)
+		}
+	}
+	if limit := v.Get("limit"); limit != "" {
+		f.Limit, err = strconv.Atoi(limit)
+		if err != nil {
+			return f, fmt.Errorf("invalid limit: %w", err)
+		}
+	}
+	return f, nil
+}
+
+func (f RunFilter) matches(run RunReport) bool {
+	if f.MainPackage != "" && !strings.Contains(run.MainPackage, f.MainPackage) {
+		return false
+	}
+	if f.Directory != "" {
+		if !within(run.Directory, f.Directory) && !within(f.Directory, run.Directory) {
+			return false
+		}
+	}
+	if !f.Since.IsZero() && run.StartedAt.Before(f.Since) {
+		return false
+	}
+	switch f.Status {
+	case RunSucceeded:
+		return run.Supervised && run.ExitCode == 0
+	case RunFailed:
+		return run.Supervised && run.ExitCode != 0
+	}
+	return true
+}
+
+// within is true if dir is parent or below it
+func within(dir, parent string) bool {
+	rel, err := filepath.Rel(parent, dir)
+	return err == nil && (filepath.IsLocal(rel) || rel == ".")
+}
+
+// history keeps the latest runs, persisted as JSON lines so that recording a
+// run only appends to the file
+type history struct {
+	path string
+	max  int
+
+	mu sync.Mutex
+	// runs are oldest first
+	runs []RunReport
+	// lines is the number of runs in the file, which may exceed max until
+	// it is next rewritten
+	lines int
+}
+
+func newHistory(path string, size int) *history {
+	return &history{path: path, max: max(size, 0)}
+}
+
+// load reads the runs recorded by previous runs of the daemon
+func (h *history) load() error {
+	f, err := os.Open(h.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			h.lines++
+			var run RunReport
+			if jsonErr := json.Unmarshal(line, &run); jsonErr != nil {
+				log.Warnf("Ignoring unreadable line of %s: %v", h.path, jsonErr)
+			} else {
+				h.runs = append(h.runs, run)
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	h.runs = h.latest()
+	log.Infof("Loaded %d runs from %s", len(h.runs), h.path)
+	return nil
+}
+
+// latest returns at most max of the latest runs. Must be called with h.mu held.
+func (h *history) latest() []RunReport {
+	if len(h.runs) <= h.max {
+		return h.runs
+	}
+	return h.runs[len(h.runs)-h.max:]
+}
+
+// add records a run. Failing to persist it only loses history, so errors
+// are logged rather than returned.
+func (h *history) add(run RunReport) {
+	if h.max <= 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.runs = append(h.runs, run)
+	h.runs = h.latest()
+
+	// Let the file grow past max a little before rewriting it
+	if h.lines >= h.max+h.max/4 {
+		h.rewrite()
+		return
+	}
+	content, err := json.Marshal(run)
+	if err != nil {
+		log.Warnf("Failed to encode run: %v", err)
+		return
+	}
+	f, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err == nil {
+		_, err = f.Write(append(content, '\n'))
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		log.Warnf("Failed to save run: %v", err)
+		return
+	}
+	h.lines++
+}
+
+// rewrite replaces the file with the runs in memory. Must be called with h.mu held.
+func (h *history) rewrite() {
+	var content []byte
+	for _, run := range h.runs {
+		line, err := json.Marshal(run)
+		if err != nil {
+			log.Warnf("Failed to encode run: %v", err)
+			continue
+		}
+		content = append(content, line...)
+		content = append(content, '\n')
+	}
+	tmp := h.path + ".tmp"
+	err := os.WriteFile(tmp, content, 0o600)
+	if err == nil {
+		err = os.Rename(tmp, h.path)
+	}
+	if err != nil {
+		log.Warnf("Failed to save run history: %v", err)
+		return
+	}
+	h.lines = len(h.runs)
+}
+
+// query returns the runs matching the filter, the latest first
+func (h *history) query(filter RunFilter) []RunReport {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	runs := []RunReport{}
+	for i := len(h.runs) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && len(runs) == filter.Limit {
+			break
+		}
+		if filter.matches(h.runs[i]) {
+			runs = append(runs, h.runs[i])
+		}
+	}
+	return runs
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	h := newHistory(path, 4)
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range 10 {
+		h.add(RunReport{
+			MainPackage: "./cmd/tool",
+			Directory:   "/src/project",
+			Args:        []string{"run", string(rune('a' + i))},
+			StartedAt:   start.Add(time.Duration(i) * time.Hour),
+			Supervised:  true,
+			ExitCode:    i % 2,
+		})
+	}
+	runs := h.query(RunFilter{})
+	assert.Len(t, runs, 4)
+	assert.Equal(t, []string{"run", "j"}, runs[0].Args)
+
+	// The file is trimmed as it grows, and survives a restart
+	assert.LessOrEqual(t, h.lines, 5)
+	loaded := newHistory(path, 4)
+	assert.NoError(t, loaded.load())
+	assert.Equal(t, runs, loaded.query(RunFilter{}))
+
+	// A negative size keeps nothing, like zero
+	disabled := newHistory(path, -1)
+	assert.NoError(t, disabled.load())
+	assert.Empty(t, disabled.query(RunFilter{}))
+}
+
+func TestRunFilter(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	run := RunReport{
+		MainPackage: "./cmd/tool",
+		Directory:   "/src/project/sub",
+		StartedAt:   start,
+		Supervised:  true,
+		ExitCode:    1,
+	}
+	cases := []struct {
+		description string
+		filter      RunFilter
+		matches     bool
+	}{
+		{"empty filter", RunFilter{}, true},
+		{"package contains", RunFilter{MainPackage: "tool"}, true},
+		{"other package", RunFilter{MainPackage: "other"}, false},
+		{"same directory", RunFilter{Directory: "/src/project/sub"}, true},
+		{"parent directory", RunFilter{Directory: "/src/project"}, true},
+		{"sibling directory", RunFilter{Directory: "/src/proj"}, false},
+		{"directory within the run's", RunFilter{Directory: "/src/project/sub/pkg"}, true},
+		{"started since", RunFilter{Since: start.Add(-time.Minute)}, true},
+		{"started before", RunFilter{Since: start.Add(time.Minute)}, false},
+		{"failed", RunFilter{Status: RunFailed}, true},
+		{"succeeded", RunFilter{Status: RunSucceeded}, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			assert.Equal(t, tc.matches, tc.filter.matches(run))
+			parsed, err := parseRunFilter(tc.filter.Query())
+			assert.NoError(t, err)
+			assert.True(t, parsed.Since.Equal(tc.filter.Since))
+		})
+	}
+
+	// Unsupervised runs have no exit code to filter on
+	assert.False(t, RunFilter{Status: RunSucceeded}.matches(RunReport{}))
+}
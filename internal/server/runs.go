@@ -0,0 +1,66 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/lukemassa/clilog"
+	"github.com/lukemassa/gorun/internal/build"
+)
+
+// RunReport is a run of a command, see POST /runs
+type RunReport struct {
+	MainPackage string
+	Directory   string
+	Flags       build.Flags
+	Args        []string
+	Executable  string
+	// BuildID identifies the build of Executable, see build.BuildID. The
+	// server fills it in.
+	BuildID   string
+	StartedAt time.Time
+	// Supervised is true if gorun watched the run to the end, see -supervise.
+	// Otherwise gorun handed over to the command, and only its start is known.
+	Supervised bool
+	Duration   time.Duration
+	// ExitCode is the command's exit status, or 128 plus the signal number if
+	// a signal killed it, the way shells report it
+	ExitCode int
+	// Signal describes the signal that killed the command, if any
+	Signal string `json:",omitempty"`
+	// PeakRSS is the most memory the command had resident at once, in bytes
+	PeakRSS int64
+}
+
+func (s *Server) handleRun(w http.ResponseWriter, r *http.Request) {
+	var report RunReport
+	if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, "Failed to parse json: %v", err)
+		return
+	}
+	report.BuildID = build.BuildID(report.Executable)
+	if report.Supervised {
+		how := fmt.Sprintf("exited with %d", report.ExitCode)
+		if report.Signal != "" {
+			how = fmt.Sprintf("was killed (%s)", report.Signal)
+		}
+		log.Infof("Run of %s in %s %s after %s, peaking at %d bytes", report.MainPackage, report.Directory, how, report.Duration, report.PeakRSS)
+	} else {
+		log.Infof("Running %s in %s", report.MainPackage, report.Directory)
+	}
+	s.history.add(report)
+	w.WriteHeader(200)
+}
+
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseRunFilter(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, "Failed to parse filter: %v", err)
+		return
+	}
+	writeJSON(w, s.history.query(filter))
+}
@@ -8,28 +8,79 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	log "github.com/lukemassa/clilog"
 	"github.com/lukemassa/gorun/internal/build"
+	"github.com/lukemassa/gorun/internal/cacheprog"
 	"github.com/lukemassa/gorun/internal/config"
+	"github.com/lukemassa/gorun/internal/remote"
+	"github.com/lukemassa/gorun/internal/watch"
 )
 
+// How long files must stop changing before a background rebuild is triggered
+const watchDebounce = 500 * time.Millisecond
+
 type Server struct {
 	srv        *http.Server
 	cache      *build.Cache
 	workingDir string
+	settings   config.Daemon
+	startedAt  time.Time
+	history    *history
 }
 
 type ExecutableRequest struct {
 	MainPackage string
+	Flags       build.Flags
 	Env         []string
+	// Toolchain is the absolute path of the go command to build with, if not the default
+	Toolchain string
+	// Stale accepts the previous build, if there is one, rather than waiting for a rebuild
+	Stale bool
+	// Fallback asks for the last good build, if there is one, when the build fails
+	Fallback bool
+	// Retry builds again even if the last build failed and the sources are unchanged
+	Retry bool
 }
 
 type ExecutableResponse struct {
-	Executable        string
+	Executable string
+	// CompilationOutput is why there is no Executable, when there is not
 	CompilationOutput string
+	// CompileFailed is true if the sources failed to compile, as opposed to
+	// gorund failing to build them
+	CompileFailed bool `json:",omitempty"`
+	// Diagnostics are the structured form of CompilationOutput, when available
+	Diagnostics []build.Diagnostic
+	// QueuePosition is the furthest back in the build queue the request had to wait, if at all
+	QueuePosition int `json:",omitempty"`
+	// Stale is true if Executable is a previous build, and a rebuild is under way
+	Stale bool `json:",omitempty"`
+	// Fallback is the last good build, when the build failed and the request asked for it
+	Fallback string `json:",omitempty"`
+	// BuiltAt is when Executable, or Fallback, was built
+	BuiltAt time.Time `json:",omitzero"`
+	// GoVersion is the version of the go command the executable is built with
+	GoVersion string `json:",omitempty"`
+	// MainPackage and Directory are what the request resolved to, such as the
+	// import path of "." and the root of its module
+	MainPackage string `json:",omitempty"`
+	Directory   string `json:",omitempty"`
+}
+
+// context is the build context the request refers to
+func (r ExecutableRequest) context() build.Context {
+	return build.Context{
+		MainPackage: r.MainPackage,
+		Directory:   valueFromEnv("PWD", r.Env),
+		Flags:       r.Flags,
+		Env:         build.BuildEnv(r.Env),
+		Toolchain:   r.Toolchain,
+	}
 }
 
 func (s *Server) sock() string {
@@ -45,30 +96,71 @@ func (s *Server) handleExecutable(w http.ResponseWriter, r *http.Request) {
 	}
 
 	log.Infof("Requested translation of %s", req.MainPackage)
-	executableContext := build.Context{
-		MainPackage: req.MainPackage,
-		Directory:   valueFromEnv("PWD", req.Env),
+	if !acceptsEvents(r) {
+		writeJSON(w, s.executable(r.Context(), req, nil))
+		return
+	}
+
+	events := newEventStream(w)
+	resp := s.executable(r.Context(), req, func(event build.Event) {
+		err := events.send(EventProgress, event)
+		if err != nil {
+			log.Debugf("Failed to send progress of %s: %v", req.MainPackage, err)
+		}
+	})
+	name := EventDone
+	if resp.Executable == "" {
+		name = EventFailed
+	}
+	err := events.send(name, resp)
+	if err != nil {
+		log.Debugf("Failed to send result for %s: %v", req.MainPackage, err)
+	}
+}
+
+// executable builds the requested executable if needed, passing progress to
+// progress if it is not nil
+func (s *Server) executable(ctx context.Context, req ExecutableRequest, progress build.Reporter) ExecutableResponse {
+	var result build.Result
+	var queuePosition int
+	ctx = build.WithReporter(ctx, func(event build.Event) {
+		if event.Kind == build.EventQueued {
+			log.Infof("Build of %s is queued at position %d", req.MainPackage, event.QueuePosition)
+			queuePosition = max(queuePosition, event.QueuePosition)
+		}
+		if progress != nil {
+			progress(event)
+		}
+	})
+	executableContext, err := s.cache.Resolve(ctx, req.context())
+	if err == nil {
+		result, err = s.cache.Get(ctx, executableContext, build.Options{AllowStale: req.Stale, Fallback: req.Fallback, Retry: req.Retry})
 	}
-	newCommand, err := s.cache.GetExecutableFromContext(executableContext)
 	resp := ExecutableResponse{
-		Executable: newCommand,
+		Executable:    result.Path,
+		QueuePosition: queuePosition,
+		Stale:         result.Stale,
+		BuiltAt:       result.BuiltAt,
+		GoVersion:     executableContext.GoVersion,
+		MainPackage:   executableContext.MainPackage,
+		Directory:     executableContext.Directory,
 	}
 	if err != nil {
 		resp.Executable = ""
+		if result.Fallback {
+			resp.Fallback = result.Path
+		}
 		resp.CompilationOutput = err.Error()
+		var compileErr *build.CompileError
+		if errors.As(err, &compileErr) {
+			resp.CompileFailed = true
+			resp.Diagnostics = compileErr.Diagnostics
+		}
 	}
-	respContent, err := json.Marshal(&resp)
-	if err != nil {
-		w.WriteHeader(500)
-		fmt.Fprintf(w, "Failed to json marshal result: %v", err)
-		return
-	}
-	w.Header().Set("Content-Type", "application/json")
-	_, _ = w.Write(respContent)
-
+	return resp
 }
 
-func (s *Server) handleDeleteExecutable(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleRebuild(w http.ResponseWriter, r *http.Request) {
 	var req ExecutableRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		w.WriteHeader(400)
@@ -76,12 +168,14 @@ func (s *Server) handleDeleteExecutable(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	log.Infof("Requested deletion of %s", req.MainPackage)
-	executableContext := build.Context{
-		MainPackage: req.MainPackage,
-		Directory:   valueFromEnv("PWD", req.Env),
+	log.Infof("Requested rebuild of %s", req.MainPackage)
+	executableContext, err := s.cache.Resolve(r.Context(), req.context())
+	if err != nil {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, "Failed to resolve %s: %v", req.MainPackage, err)
+		return
 	}
-	err := s.cache.Recompile(executableContext)
+	err = s.cache.Recompile(r.Context(), executableContext, build.Interactive)
 	if err != nil {
 		w.WriteHeader(500)
 		fmt.Fprintf(w, "Failed to recompile: %v", err)
@@ -91,15 +185,34 @@ func (s *Server) handleDeleteExecutable(w http.ResponseWriter, r *http.Request)
 	fmt.Fprintf(w, "Recompiled %+v", executableContext)
 }
 
-func NewServer(workingDir string) *Server {
+func NewServer(workingDir string, settings config.Daemon) *Server {
 
 	s := &Server{
 		cache:      build.NewCache(workingDir, &build.DefaultCompiler{}),
 		workingDir: workingDir,
+		settings:   settings,
+		startedAt:  time.Now(),
+		history:    newHistory(config.HistoryFile(workingDir), settings.HistorySize),
+	}
+	s.cache.SetBuildTimeout(settings.BuildTimeout)
+	s.cache.SetMaxParallelBuilds(settings.MaxParallelBuilds)
+	if settings.RemoteCache != "" {
+		backend, err := remote.NewBackend(settings.RemoteCache)
+		if err != nil {
+			log.Warnf("Not using the remote cache: %v", err)
+		} else {
+			s.cache.SetBackend(backend)
+		}
 	}
 	mux := http.NewServeMux()
 	mux.HandleFunc("POST /command", s.handleExecutable)
-	mux.HandleFunc("DELETE /command", s.handleDeleteExecutable)
+	mux.HandleFunc("POST /command/rebuild", s.handleRebuild)
+	mux.HandleFunc("DELETE /command", s.handleEvict)
+	mux.HandleFunc("GET /commands", s.handleList)
+	mux.HandleFunc("DELETE /commands", s.handleClean)
+	mux.HandleFunc("GET /status", s.handleStatus)
+	mux.HandleFunc("POST /runs", s.handleRun)
+	mux.HandleFunc("GET /runs", s.handleHistory)
 
 	s.srv = &http.Server{
 		Handler: mux,
@@ -107,7 +220,16 @@ func NewServer(workingDir string) *Server {
 	return s
 }
 
+// Run serves until the daemon is told to stop with SIGINT or SIGTERM
 func (s *Server) Run() {
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-signals
+		log.Infof("Got %s, shutting down", sig)
+		// Serve returns straight away, while this waits for requests in flight
+		_ = s.srv.Shutdown(context.Background())
+	}()
 
 	if err := s.serve(); err != nil {
 		panic(err)
@@ -116,6 +238,16 @@ func (s *Server) Run() {
 
 func (s *Server) serve() (err error) {
 
+	err = s.cache.Load()
+	if err != nil {
+		log.Warnf("Failed to load cache manifest, starting empty: %v", err)
+	}
+	defer s.cache.Flush()
+	err = s.history.load()
+	if err != nil {
+		log.Warnf("Failed to load run history: %v", err)
+	}
+
 	_ = os.Remove(s.sock())
 
 	l, err := net.Listen("unix", s.sock())
@@ -125,6 +257,28 @@ func (s *Server) serve() (err error) {
 	defer l.Close()
 
 	log.Infof("Starting server at %s", s.sock())
+	notifyReady()
+
+	watcher, err := watch.New(s.cache, watchDebounce)
+	if err != nil {
+		log.Warnf("Not watching for file changes: %v", err)
+	} else {
+		s.cache.SetWatcher(watcher)
+		defer watcher.Close()
+		go func() {
+			err := watcher.Run()
+			if err != nil {
+				log.Errorf("File watcher stopped: %v", err)
+				s.cache.SetWatcher(nil)
+			}
+		}()
+	}
+
+	if s.settings.GCInterval > 0 {
+		stop := make(chan struct{})
+		defer close(stop)
+		go s.collectGarbage(stop)
+	}
 
 	err = s.srv.Serve(l)
 	if err != nil && !errors.Is(err, http.ErrServerClosed) {
@@ -133,6 +287,49 @@ func (s *Server) serve() (err error) {
 	return nil
 }
 
+// collectGarbage periodically shrinks the cache until stop is closed
+func (s *Server) collectGarbage(stop <-chan struct{}) {
+	policy := build.GCPolicy{
+		MaxBytes:         s.settings.CacheMaxBytes,
+		MaxEntriesPerKey: s.settings.CacheMaxEntriesPerKey,
+		MaxAge:           s.settings.CacheMaxAge,
+	}
+	ticker := time.NewTicker(s.settings.GCInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			_, err := s.cache.Collect(policy)
+			if err != nil {
+				log.Warnf("Garbage collection failed: %v", err)
+			}
+			s.collectGoCache()
+		}
+	}
+}
+
+// collectGoCache shrinks the build cache kept by `gorund cacheprog`, if any
+func (s *Server) collectGoCache() {
+	dir := config.GoCacheDir(s.workingDir)
+	if _, err := os.Stat(dir); err != nil {
+		return
+	}
+	store, err := cacheprog.NewStore(dir)
+	if err != nil {
+		log.Warnf("Go build cache collection failed: %v", err)
+		return
+	}
+	_, err = store.Collect(build.GCPolicy{
+		MaxBytes: s.settings.GoCacheMaxBytes,
+		MaxAge:   s.settings.CacheMaxAge,
+	})
+	if err != nil {
+		log.Warnf("Go build cache collection failed: %v", err)
+	}
+}
+
 func (s *Server) Start() (stop func(), err error) {
 
 	go s.serve()
@@ -0,0 +1,111 @@
+package watch
+
+import (
+	"bytes"
+	"errors"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_CLOSE_WRITE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+
+type inotify struct {
+	fd int
+	// file wraps fd so that reads go through the runtime poller, and so
+	// that closing it unblocks run
+	file *os.File
+
+	mu      sync.Mutex
+	watches map[string]int
+	dirs    map[int]string
+}
+
+func newNotifier() (notifier, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	return &inotify{
+		fd:      fd,
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		watches: make(map[string]int),
+		dirs:    make(map[int]string),
+	}, nil
+}
+
+func (n *inotify) add(dir string) error {
+	wd, err := syscall.InotifyAddWatch(n.fd, dir, inotifyMask)
+	if err != nil {
+		return os.NewSyscallError("inotify_add_watch", err)
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.watches[dir] = wd
+	n.dirs[wd] = dir
+	return nil
+}
+
+func (n *inotify) remove(dir string) error {
+	n.mu.Lock()
+	wd, ok := n.watches[dir]
+	delete(n.watches, dir)
+	delete(n.dirs, wd)
+	n.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	_, err := syscall.InotifyRmWatch(n.fd, uint32(wd))
+	if err != nil && err != syscall.EINVAL {
+		// EINVAL means the directory was already deleted, which removes the watch
+		return os.NewSyscallError("inotify_rm_watch", err)
+	}
+	return nil
+}
+
+func (n *inotify) run(changed func(dir, name string)) error {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		count, err := n.file.Read(buf)
+		if err != nil {
+			if errors.Is(err, os.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= count; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			offset = nameStart + int(event.Len)
+			if event.Mask&syscall.IN_IGNORED != 0 {
+				continue
+			}
+			if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				// Events were lost, so any directory may have changed
+				n.mu.Lock()
+				dirs := slices.Collect(maps.Values(n.dirs))
+				n.mu.Unlock()
+				for _, dir := range dirs {
+					changed(dir, "")
+				}
+				continue
+			}
+			name := string(bytes.TrimRight(buf[nameStart:offset], "\x00"))
+
+			n.mu.Lock()
+			dir, ok := n.dirs[int(event.Wd)]
+			n.mu.Unlock()
+			if ok {
+				changed(dir, name)
+			}
+		}
+	}
+}
+
+func (n *inotify) close() error {
+	return n.file.Close()
+}
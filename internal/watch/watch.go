@@ -0,0 +1,219 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/lukemassa/clilog"
+	"github.com/lukemassa/gorun/internal/build"
+)
+
+// Rebuilder is what the Watcher calls when files behind a context change.
+// Changed is called straight away, and Refresh once the files settle down.
+// Refresh must only rebuild if the change affects the build, since most
+// changes, say to docs or tests, do not.
+type Rebuilder interface {
+	Changed(c build.Context)
+	Refresh(ctx context.Context, c build.Context, priority build.Priority) error
+}
+
+// notifier is the OS-specific mechanism that reports changes to directories
+type notifier interface {
+	add(dir string) error
+	remove(dir string) error
+	// run blocks, calling changed for every file that changes, until close is
+	// called. An empty name means something in dir may have changed.
+	run(changed func(dir, name string)) error
+	close() error
+}
+
+// Watcher watches the package directories of every cached context, and
+// rebuilds a context once its files have stopped changing for the debounce period
+type Watcher struct {
+	rebuilder Rebuilder
+	debounce  time.Duration
+	notifier  notifier
+
+	mu       sync.Mutex
+	contexts map[string]*watched
+	// dirs maps a directory to the keys of every context that depends on it
+	dirs map[string]map[string]bool
+}
+
+type watched struct {
+	context build.Context
+	dirs    []string
+	timer   *time.Timer
+}
+
+func New(rebuilder Rebuilder, debounce time.Duration) (*Watcher, error) {
+	n, err := newNotifier()
+	if err != nil {
+		return nil, err
+	}
+	return newWatcher(rebuilder, debounce, n), nil
+}
+
+func newWatcher(rebuilder Rebuilder, debounce time.Duration, n notifier) *Watcher {
+	return &Watcher{
+		rebuilder: rebuilder,
+		debounce:  debounce,
+		notifier:  n,
+		contexts:  make(map[string]*watched),
+		dirs:      make(map[string]map[string]bool),
+	}
+}
+
+// Run processes file changes until Close is called
+func (w *Watcher) Run() error {
+	return w.notifier.run(w.changed)
+}
+
+func (w *Watcher) Close() error {
+	w.mu.Lock()
+	for _, c := range w.contexts {
+		if c.timer != nil {
+			c.timer.Stop()
+		}
+	}
+	w.mu.Unlock()
+	return w.notifier.close()
+}
+
+// Watch sets the directories that the context depends on, replacing any
+// previous set. It returns an error if any of them can't be watched.
+func (w *Watcher) Watch(c build.Context, dirs []string) error {
+	key := c.Key()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	existing, ok := w.contexts[key]
+	if !ok {
+		existing = &watched{}
+		w.contexts[key] = existing
+	}
+	// Acquire before releasing, so that directories in both sets are
+	// watched throughout
+	var errs []error
+	for _, dir := range dirs {
+		errs = append(errs, w.acquire(key, dir))
+	}
+	for _, dir := range existing.dirs {
+		if !slices.Contains(dirs, dir) {
+			w.release(key, dir)
+		}
+	}
+	existing.context = c
+	existing.dirs = dirs
+	return errors.Join(errs...)
+}
+
+// Unwatch stops watching on behalf of the context
+func (w *Watcher) Unwatch(c build.Context) {
+	key := c.Key()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	existing, ok := w.contexts[key]
+	if !ok {
+		return
+	}
+	if existing.timer != nil {
+		existing.timer.Stop()
+	}
+	for _, dir := range existing.dirs {
+		w.release(key, dir)
+	}
+	delete(w.contexts, key)
+}
+
+// acquire records that key depends on dir, watching it if this is the first
+// such key. Must be called with the lock held.
+func (w *Watcher) acquire(key, dir string) error {
+	keys, ok := w.dirs[dir]
+	if !ok {
+		err := w.notifier.add(dir)
+		if err != nil {
+			return fmt.Errorf("watching %s: %w", dir, err)
+		}
+		keys = make(map[string]bool)
+		w.dirs[dir] = keys
+	}
+	keys[key] = true
+	return nil
+}
+
+// release is the inverse of acquire. Must be called with the lock held.
+func (w *Watcher) release(key, dir string) {
+	keys, ok := w.dirs[dir]
+	if !ok {
+		return
+	}
+	delete(keys, key)
+	if len(keys) > 0 {
+		return
+	}
+	delete(w.dirs, dir)
+	err := w.notifier.remove(dir)
+	if err != nil {
+		log.Warnf("Failed to stop watching %s: %v", dir, err)
+	}
+}
+
+func (w *Watcher) changed(dir, name string) {
+	if ignoredFile(name) {
+		return
+	}
+	w.mu.Lock()
+	var changed []build.Context
+	for key := range w.dirs[dir] {
+		c := w.contexts[key]
+		changed = append(changed, c.context)
+		if c.timer != nil {
+			c.timer.Reset(w.debounce)
+			continue
+		}
+		c.timer = time.AfterFunc(w.debounce, func() {
+			w.rebuild(key)
+		})
+	}
+	w.mu.Unlock()
+
+	// Outside the lock, since the Rebuilder may be busy telling us what to watch
+	for _, c := range changed {
+		w.rebuilder.Changed(c)
+	}
+}
+
+func (w *Watcher) rebuild(key string) {
+	w.mu.Lock()
+	c, ok := w.contexts[key]
+	if !ok {
+		w.mu.Unlock()
+		return
+	}
+	c.timer = nil
+	executableContext := c.context
+	w.mu.Unlock()
+
+	log.Debugf("Files changed for %+v, checking whether to rebuild", executableContext)
+	err := w.rebuilder.Refresh(context.Background(), executableContext, build.Background)
+	if err != nil {
+		log.Warnf("Background rebuild of %+v failed: %v", executableContext, err)
+	}
+}
+
+// ignoredFile is true for editor swap and backup files, and tests, which never
+// affect a build. Anything else is left to the Rebuilder to check.
+func ignoredFile(name string) bool {
+	return strings.HasSuffix(name, "_test.go") ||
+		strings.HasPrefix(name, ".") ||
+		strings.HasPrefix(name, "#") ||
+		strings.HasSuffix(name, "~") ||
+		strings.HasSuffix(name, ".swp")
+}
@@ -0,0 +1,136 @@
+package watch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lukemassa/gorun/internal/build"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockNotifier struct {
+	watched map[string]bool
+}
+
+func (m *mockNotifier) add(dir string) error {
+	m.watched[dir] = true
+	return nil
+}
+
+func (m *mockNotifier) remove(dir string) error {
+	delete(m.watched, dir)
+	return nil
+}
+
+func (m *mockNotifier) run(changed func(dir, name string)) error {
+	return nil
+}
+
+func (m *mockNotifier) close() error {
+	return nil
+}
+
+type mockRebuilder struct {
+	mu         sync.Mutex
+	changed    map[string]int
+	recompiled map[string]int
+}
+
+func newMockRebuilder() *mockRebuilder {
+	return &mockRebuilder{
+		changed:    make(map[string]int),
+		recompiled: make(map[string]int),
+	}
+}
+
+func (m *mockRebuilder) Changed(c build.Context) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.changed[c.MainPackage]++
+}
+
+func (m *mockRebuilder) Refresh(ctx context.Context, c build.Context, priority build.Priority) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.recompiled[c.MainPackage]++
+	return nil
+}
+
+func (m *mockRebuilder) count(mainPackage string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.recompiled[mainPackage]
+}
+
+func TestWatchSharedDirectories(t *testing.T) {
+	n := &mockNotifier{watched: make(map[string]bool)}
+	w := newWatcher(newMockRebuilder(), time.Millisecond, n)
+
+	first := build.Context{MainPackage: "./first"}
+	second := build.Context{MainPackage: "./second"}
+
+	w.Watch(first, []string{"/src/first", "/src/lib"})
+	w.Watch(second, []string{"/src/second", "/src/lib"})
+	assert.Equal(t, map[string]bool{"/src/first": true, "/src/second": true, "/src/lib": true}, n.watched)
+
+	// first no longer depends on lib, but second still does
+	w.Watch(first, []string{"/src/first"})
+	assert.Equal(t, map[string]bool{"/src/first": true, "/src/second": true, "/src/lib": true}, n.watched)
+
+	w.Unwatch(second)
+	assert.Equal(t, map[string]bool{"/src/first": true}, n.watched)
+
+	w.Unwatch(first)
+	assert.Empty(t, n.watched)
+}
+
+func TestChangesAreDebounced(t *testing.T) {
+	n := &mockNotifier{watched: make(map[string]bool)}
+	rebuilder := newMockRebuilder()
+	w := newWatcher(rebuilder, 50*time.Millisecond, n)
+
+	w.Watch(build.Context{MainPackage: "./first"}, []string{"/src/first", "/src/lib"})
+	w.Watch(build.Context{MainPackage: "./second"}, []string{"/src/second", "/src/lib"})
+
+	for range 5 {
+		w.changed("/src/lib", "lib.go")
+	}
+	w.changed("/src/first", ".main.go.swp")
+
+	// Every change is passed on straight away, it's only refreshing that waits
+	rebuilder.mu.Lock()
+	assert.Equal(t, map[string]int{"./first": 5, "./second": 5}, rebuilder.changed)
+	rebuilder.mu.Unlock()
+
+	assert.Eventually(t, func() bool {
+		return rebuilder.count("./first") == 1 && rebuilder.count("./second") == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// Give any extra rebuilds a chance to (incorrectly) happen
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 1, rebuilder.count("./first"))
+	assert.Equal(t, 1, rebuilder.count("./second"))
+}
+
+func TestWatcherSeesFileChanges(t *testing.T) {
+	dir := t.TempDir()
+	rebuilder := newMockRebuilder()
+	w, err := New(rebuilder, 10*time.Millisecond)
+	if err != nil {
+		t.Skipf("file watching unavailable: %v", err)
+	}
+	go w.Run()
+	defer w.Close()
+
+	w.Watch(build.Context{MainPackage: "."}, []string{dir})
+	err = os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main"), 0o644)
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return rebuilder.count(".") == 1
+	}, time.Second, 10*time.Millisecond)
+}